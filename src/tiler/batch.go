@@ -0,0 +1,157 @@
+package tiler
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Example is a machine found by FindExamples, with the cases listed for it
+type Example struct {
+	MachineFile string
+	Cases       []Case
+	Options     *Options // if set, used in place of the options it's run with
+}
+
+// the options to run an example with
+func (o *Options) forExample(e *Example) Options {
+	options := *o
+	if e.Options != nil {
+		options = *e.Options
+	}
+	options.MachineFile = e.MachineFile
+	return options
+}
+
+// FindExamples looks in each directory for <name>.machine files, with the
+// cases in <name>.inputs beside each one. machine files may also be named
+// directly. machines without an inputs file are still found, so that their
+// diagrams are made.
+func FindExamples(paths []string) []Example {
+	var examples []Example
+	for _, path := range paths {
+		machines := []string{path}
+		if !strings.HasSuffix(path, ".machine") {
+			var err error
+			if machines, err = filepath.Glob(filepath.Join(path, "*.machine")); err != nil {
+				log.Panicf("Couldn't search %s: %s", path, err)
+			}
+			if len(machines) == 0 {
+				log.Printf("Warning: no machines in %s", path)
+			}
+		}
+		for _, file := range machines {
+			examples = append(examples, Example{
+				MachineFile: file,
+				Cases:       readInputs(strings.TrimSuffix(file, ".machine") + ".inputs"),
+			})
+		}
+	}
+	return examples
+}
+
+// the directory an example's images go in: beside the machine, or under
+// OutputDir in a directory named after the one the machine is in
+func (o *Options) exampleDir(e *Example) string {
+	dir := filepath.Dir(e.MachineFile)
+	if o.OutputDir == "" {
+		return dir
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return filepath.Join(o.OutputDir, filepath.Base(dir))
+}
+
+// RunExamples redraws the diagram of every example and assembles all of their
+// inputs, jobs at a time, each with its own tiler. a table of how every run
+// ended is written to w. it returns the number of runs which failed to halt,
+// including any which panicked.
+func (o *Options) RunExamples(examples []Example, jobs int, w io.Writer) int {
+	type job struct {
+		example, input int
+	}
+	outcomes := make([][]Outcome, len(examples))
+	queue := make(chan job)
+	var wg sync.WaitGroup
+	if jobs < 1 {
+		jobs = 1
+	}
+	for n := 0; n < jobs; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				e := &examples[j.example]
+				outcomes[j.example][j.input] = o.runExample(e, e.Cases[j.input].Input)
+			}
+		}()
+	}
+
+	for i := range examples {
+		e := &examples[i]
+		options := o.forExample(e)
+		options.OutputDir = options.exampleDir(e)
+		if failure := catchPanic(func() { options.WriteDiagram("png", true) }); failure != "" {
+			log.Printf("  Warning: couldn't draw the diagram of %s: %s", e.MachineFile, failure)
+		}
+		outcomes[i] = make([]Outcome, len(e.Cases))
+	}
+	for i := range examples {
+		for k := range examples[i].Cases {
+			queue <- job{i, k}
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	failures, runs := 0, 0
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MACHINE\tINPUT\tSTEPS\tOUTPUT\tRESULT")
+	for i, e := range examples {
+		for _, outcome := range outcomes[i] {
+			steps, output, result := strconv.Itoa(outcome.Steps), outcome.Output, "ok"
+			if outcome.Failure != "" {
+				result = "FAILED: " + outcome.Failure
+				failures++
+			}
+			if output == "" {
+				output = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.MachineFile, outcome.Input, steps, output, result)
+			runs++
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d runs, %d failed\n", runs, failures)
+	return failures
+}
+
+// runExample assembles one input of an example with a tiler of its own, since
+// tilers cache colors as they draw and so can't be shared between goroutines
+func (o *Options) runExample(e *Example, input string) (outcome Outcome) {
+	options := o.forExample(e)
+	options.OutputDir = options.exampleDir(e)
+	options.Inputs = []string{input}
+	if failure := catchPanic(func() { outcome = options.NewTiler().AssembleOne(input) }); failure != "" {
+		outcome = Outcome{Input: input, Failure: failure}
+	}
+	return outcome
+}
+
+// catchPanic runs f, returning the message it panicked with if it did, so
+// that one bad machine or input doesn't stop a whole batch
+func catchPanic(f func()) (failure string) {
+	defer func() {
+		if r := recover(); r != nil {
+			failure = fmt.Sprint(r)
+		}
+	}()
+	f()
+	return ""
+}
@@ -0,0 +1,406 @@
+package tiler
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// checkpoint files start with the magic string and a version, which is
+// bumped whenever the layout below changes
+const (
+	checkpointMagic   = "TTCK"
+	checkpointVersion = 2
+)
+
+// what a checkpoint holds
+const (
+	checkpointSimulation = 1
+	checkpointAssembly   = 2
+)
+
+// checkpointKinds names the kinds of checkpoint in errors
+var checkpointKinds = map[int]string{
+	checkpointSimulation: "a simulation",
+	checkpointAssembly:   "an assembly",
+}
+
+// a checkpoint is a run partway through, saved so that it can be resumed. a
+// simulation is its configuration; an assembly is how many rows it has
+// emitted, which are kept in its row log, and the top rows still being
+// assembled.
+//
+// the file is the magic string, then uvarints: the version, the kind, the
+// length of the machine hash and the hash, and the input as a string. strings
+// are a uvarint length and the bytes. a simulation goes on with the step
+// count, head, halted (0 or 1), state, output and tape as a string. an
+// assembly goes on with the names of the tiles rows are coded by, the width of
+// the rows, the number of emitted rows, the size of the row log they fill and
+// the number of top rows, then each top row as a uvarint per tile: 0 for none,
+// or 1 more than the tile's place in the names. seed tiles are named
+// "seed <column>".
+type checkpoint struct {
+	kind    int
+	machine []byte // hash of the machine, so that an edited one isn't resumed
+	input   string
+
+	Config
+	Steps int
+
+	emitted int
+	logSize int64
+	window  Assembly
+}
+
+// the hash a checkpoint is tied to a machine by
+func (t *Tiler) machineHash() []byte {
+	m := t.Machine
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%q %q %v %q %d %q", m.Name, string(m.Symbols), m.Transitions,
+		m.InitialState, m.InitialLocation, t.BoundarySymbol)))
+	return sum[:]
+}
+
+// tileTable lists the tiles the rows of an assembly from seed are coded by,
+// with nil for none first, and their names. the machine's tiles are generated
+// in a different order each run, so they're listed by name.
+func (t *Tiler) tileTable(seed []*Tile) (tiles []*Tile, names []string) {
+	tiles = append(tiles, nil)
+	for i, tile := range seed {
+		tiles = append(tiles, tile)
+		names = append(names, fmt.Sprintf("seed %d", i))
+	}
+	var machine []*Tile
+	for i := range t.tiles {
+		machine = append(machine, &t.tiles[i])
+	}
+	sort.Slice(machine, func(i, j int) bool { return machine[i].Name < machine[j].Name })
+	for _, tile := range machine {
+		tiles = append(tiles, tile)
+		names = append(names, tile.Name)
+	}
+	return tiles, names
+}
+
+// a rowLog is the file beside an assembly's checkpoint which the rows are
+// added to as they're emitted, each as a uvarint code per tile, so that a
+// checkpoint only has to say how much of it was written
+type rowLog struct {
+	file  string
+	f     *os.File
+	w     *bufio.Writer
+	tiles []*Tile
+	codes map[*Tile]int
+	rows  int
+	size  int64
+}
+
+// openRowLog opens the row log of an assembly from seed. with a checkpoint,
+// the rows it says were emitted are read back and passed to emit, and anything
+// written after it was saved is dropped; without one the log starts empty.
+func (t *Tiler) openRowLog(file string, seed []*Tile, c *checkpoint, emit func(row []*Tile)) *rowLog {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Panicf("Couldn't open %s: %s", file, err)
+	}
+	l := rowLog{file: file, f: f, codes: make(map[*Tile]int)}
+	l.tiles, _ = t.tileTable(seed)
+	for code, tile := range l.tiles {
+		l.codes[tile] = code
+	}
+
+	if c != nil {
+		r := bufio.NewReader(io.LimitReader(f, c.logSize))
+		for ; l.rows < c.emitted; l.rows++ {
+			row := make([]*Tile, len(seed))
+			for x := range row {
+				code, err := binary.ReadUvarint(r)
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				if err == nil && code >= uint64(len(l.tiles)) {
+					err = fmt.Errorf("damaged")
+				}
+				if err != nil {
+					log.Panicf("Couldn't resume from %s: %s", file, err)
+				}
+				row[x] = l.tiles[code]
+			}
+			emit(row)
+		}
+		l.size = c.logSize
+	}
+	if err := f.Truncate(l.size); err != nil {
+		log.Panicf("Couldn't write %s: %s", file, err)
+	}
+	if _, err := f.Seek(l.size, io.SeekStart); err != nil {
+		log.Panicf("Couldn't write %s: %s", file, err)
+	}
+	l.w = bufio.NewWriter(f)
+	return &l
+}
+
+// add writes an emitted row to the log
+func (l *rowLog) add(row []*Tile) {
+	var buf [binary.MaxVarintLen64]byte
+	for _, tile := range row {
+		code, ok := l.codes[tile]
+		if !ok {
+			log.Panicf("Couldn't write %s: tile %q isn't in the table", l.file, tile.Name)
+		}
+		n, _ := l.w.Write(buf[:binary.PutUvarint(buf[:], uint64(code))])
+		l.size += int64(n)
+	}
+	l.rows++
+}
+
+// flush writes out the rows added so far, before a checkpoint says they're
+// there
+func (l *rowLog) flush() {
+	if err := l.w.Flush(); err != nil {
+		log.Panicf("Couldn't write %s: %s", l.file, err)
+	}
+}
+
+func (l *rowLog) close() {
+	l.flush()
+	if err := l.f.Close(); err != nil {
+		log.Panicf("Couldn't write %s: %s", l.file, err)
+	}
+}
+
+// assembleCheckpointed assembles an input as assembleRows does, passing each
+// row to emit, but saves a checkpoint every CheckpointInterval and with Resume
+// carries on from the last one saved, first passing emit the rows emitted
+// before it. the checkpoint and its row log are removed once the run ends,
+// whether or not it halted, but kept if it dies.
+func (t *Tiler) assembleCheckpointed(input string, emit func(row []*Tile)) bool {
+	if t.CheckpointInterval <= 0 && !t.Resume {
+		return t.assembleRows(input, emit)
+	}
+
+	file := t.outputFile(input, "-assembly", "checkpoint")
+	seed := t.seedRow(input)
+	window := Assembly{seed}
+	var c *checkpoint
+	if t.Resume {
+		if c = t.readCheckpoint(file, input, checkpointAssembly, seed); c != nil {
+			log.Printf("Resuming from %s after %d rows...", file, c.emitted)
+			window = c.window
+		}
+	}
+	rows := t.openRowLog(file+".rows", seed, c, emit)
+
+	saved := time.Now()
+	save := func(window Assembly) {
+		if t.CheckpointInterval > 0 && time.Since(saved) >= t.CheckpointInterval {
+			rows.flush()
+			t.writeCheckpoint(file, &checkpoint{kind: checkpointAssembly, input: input,
+				emitted: rows.rows, logSize: rows.size, window: window}, seed)
+			saved = time.Now()
+		}
+	}
+	log.Printf("Assembling transition tiles...")
+	completed, _ := t.assembleFrom(context.Background(), window, rows.rows, func(row []*Tile) {
+		rows.add(row)
+		emit(row)
+	}, save)
+	rows.close()
+	os.Remove(file)
+	os.Remove(rows.file)
+	return completed
+}
+
+// resumeSimulation puts a simulator in the configuration of a checkpoint, if
+// Resume is set and there is one, returning whether it did
+func (t *Tiler) resumeSimulation(s *Simulator, file, input string) bool {
+	if !t.Resume {
+		return false
+	}
+	c := t.readCheckpoint(file, input, checkpointSimulation, nil)
+	if c == nil {
+		return false
+	}
+	log.Printf("Resuming from %s at step %d...", file, c.Steps)
+	s.Config, s.Steps = c.Config, c.Steps
+	return true
+}
+
+func (t *Tiler) saveSimulation(s *Simulator, file, input string) {
+	c := checkpoint{kind: checkpointSimulation, input: input, Config: s.Config, Steps: s.Steps}
+	t.writeCheckpoint(file, &c, nil)
+}
+
+// writeCheckpoint saves a checkpoint, replacing the file only once it's
+// complete so that dying partway through leaves the last one intact
+func (t *Tiler) writeCheckpoint(file string, c *checkpoint, seed []*Tile) {
+	log.Printf("Saving checkpoint %s...", file)
+	f, err := os.Create(file + ".tmp")
+	if err != nil {
+		log.Panicf("Couldn't create %s: %s", file+".tmp", err)
+	}
+	w := bufio.NewWriter(f)
+	put := func(v int) {
+		var buf [binary.MaxVarintLen64]byte
+		w.Write(buf[:binary.PutUvarint(buf[:], uint64(v))])
+	}
+	putString := func(s string) {
+		put(len(s))
+		w.WriteString(s)
+	}
+
+	w.WriteString(checkpointMagic)
+	put(checkpointVersion)
+	put(c.kind)
+	putString(string(t.machineHash()))
+	putString(c.input)
+	switch c.kind {
+	case checkpointSimulation:
+		halted := 0
+		if c.Halted {
+			halted = 1
+		}
+		put(c.Steps)
+		put(c.Head)
+		put(halted)
+		putString(c.State)
+		putString(c.Output)
+		putString(string(c.Tape))
+	case checkpointAssembly:
+		tiles, names := t.tileTable(seed)
+		codes := make(map[*Tile]int)
+		for code, tile := range tiles {
+			codes[tile] = code
+		}
+		put(len(names))
+		for _, name := range names {
+			putString(name)
+		}
+		put(len(seed))
+		put(c.emitted)
+		put(int(c.logSize))
+		put(len(c.window))
+		for _, row := range c.window {
+			for _, tile := range row {
+				put(codes[tile])
+			}
+		}
+	}
+
+	err = w.Flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file+".tmp", file)
+	}
+	if err != nil {
+		log.Panicf("Couldn't write %s: %s", file, err)
+	}
+}
+
+// readCheckpoint loads a checkpoint of a kind for an input, or returns nil if
+// there isn't one. it panics if the file is damaged, of another version, or
+// was saved from a different machine, input or kind of run. seed is the
+// seed row of an assembly, which seed tiles are restored from.
+func (t *Tiler) readCheckpoint(file, input string, kind int, seed []*Tile) *checkpoint {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		log.Printf("  Warning: no checkpoint %s to resume from, starting from the beginning", file)
+		return nil
+	} else if err != nil {
+		log.Panicf("Couldn't open %s: %s", file, err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	fail := func(format string, args ...interface{}) {
+		log.Panicf("Couldn't resume from %s: %s", file, fmt.Sprintf(format, args...))
+	}
+	get := func() int {
+		v, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			fail("%s", err)
+		}
+		return int(v)
+	}
+	getString := func() string {
+		n := get()
+		if n > 1<<30 {
+			fail("damaged")
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			fail("%s", err)
+		}
+		return string(buf)
+	}
+
+	magic := make([]byte, len(checkpointMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != checkpointMagic {
+		fail("not a checkpoint")
+	}
+	if v := get(); v != checkpointVersion {
+		fail("version %d, expected %d", v, checkpointVersion)
+	}
+	c := checkpoint{kind: get()}
+	if c.kind != kind {
+		fail("checkpoint is of %s, not %s", checkpointKinds[c.kind], checkpointKinds[kind])
+	}
+	if getString() != string(t.machineHash()) {
+		fail("the machine has changed since it was saved")
+	}
+	if c.input = getString(); c.input != input {
+		fail("saved for input %q", c.input)
+	}
+
+	switch kind {
+	case checkpointSimulation:
+		c.Steps = get()
+		c.Head = get()
+		c.Halted = get() == 1
+		c.State = getString()
+		c.Output = getString()
+		c.Tape = []rune(getString())
+		if c.Head >= len(c.Tape) {
+			fail("damaged")
+		}
+	case checkpointAssembly:
+		tiles, names := t.tileTable(seed)
+		if get() != len(names) {
+			fail("the tiles have changed since it was saved")
+		}
+		for _, name := range names {
+			if getString() != name {
+				fail("the tiles have changed since it was saved")
+			}
+		}
+		width := get()
+		c.emitted = get()
+		c.logSize = int64(get())
+		top := get()
+		if width != len(seed) || top == 0 || top > 3 {
+			fail("damaged")
+		}
+		for i := 0; i < top; i++ {
+			row := make([]*Tile, width)
+			for x := range row {
+				code := get()
+				if code >= len(tiles) {
+					fail("damaged")
+				}
+				row[x] = tiles[code]
+			}
+			c.window = append(c.window, row)
+		}
+	}
+	return &c
+}
@@ -0,0 +1,274 @@
+package tiler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a tiler of a machine written to dir, with its tiles
+func newCheckpointTiler(t *testing.T, dir, def string) *Tiler {
+	machineFile := filepath.Join(dir, "invert.machine")
+	if err := ioutil.WriteFile(machineFile, []byte(def), 0644); err != nil {
+		t.Fatal(err)
+	}
+	options := Options{
+		TileWidth:      32,
+		TileHeight:     24,
+		FontSize:       9,
+		BoundarySymbol: '*',
+		MachineFile:    machineFile,
+		OutputDir:      dir,
+	}
+	return options.NewTiler()
+}
+
+// the rows emitted and the top rows of an assembly of input from seed when
+// it's first saved
+func firstSave(tiler *Tiler, seed []*Tile) (rows, window Assembly) {
+	var assembly Assembly
+	emit := func(row []*Tile) { assembly = append(assembly, row) }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	save := func(top Assembly) {
+		if window == nil {
+			rows, window = append(Assembly{}, assembly...), append(Assembly{}, top...)
+			cancel()
+		}
+	}
+	tiler.assembleFrom(ctx, Assembly{seed}, 0, emit, save)
+	return rows, window
+}
+
+// saves an assembly of input, with its row log, as it's first saved
+func saveAssembly(tiler *Tiler, file, input string) {
+	seed := tiler.seedRow(input)
+	rows, window := firstSave(tiler, seed)
+	l := tiler.openRowLog(file+".rows", seed, nil, nil)
+	for _, row := range rows {
+		l.add(row)
+	}
+	l.close()
+	tiler.writeCheckpoint(file, &checkpoint{kind: checkpointAssembly, input: input,
+		emitted: l.rows, logSize: l.size, window: window}, seed)
+}
+
+func panics(f func()) (panicked bool) {
+	defer func() {
+		if recover() != nil {
+			panicked = true
+		}
+	}()
+	f()
+	return false
+}
+
+func sameRows(a, b Assembly) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for y := range a {
+		if len(a[y]) != len(b[y]) {
+			return false
+		}
+		for x := range a[y] {
+			if a[y][x] != b[y][x] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestCheckpointSimulation(t *testing.T) {
+	dir := t.TempDir()
+	tiler := newCheckpointTiler(t, dir, invertMachine)
+	file := filepath.Join(dir, "invert.checkpoint")
+	for step := range invertRun {
+		s := tiler.NewSimulator("011")
+		s.Seek(step)
+		tiler.saveSimulation(s, file, "011")
+		c := tiler.readCheckpoint(file, "011", checkpointSimulation, nil)
+		s.Config, s.Steps = c.Config, c.Steps
+		checkConfig(t, s, step)
+	}
+}
+
+func TestCheckpointAssembly(t *testing.T) {
+	dir := t.TempDir()
+	tiler := newCheckpointTiler(t, dir, invertMachine)
+	file := filepath.Join(dir, "invert.checkpoint")
+	rows, window := firstSave(tiler, tiler.seedRow("011"))
+	if len(rows) == 0 {
+		t.Fatalf("no rows emitted by the first save")
+	}
+	saveAssembly(tiler, file, "011")
+	// rows added after the checkpoint was saved are dropped when resuming
+	l := tiler.openRowLog(file+".rows", rows[0], tiler.readCheckpoint(file, "011", checkpointAssembly, rows[0]), func([]*Tile) {})
+	l.add(window[0])
+	l.close()
+
+	// a resumed run has a seed row of its own
+	seed := tiler.seedRow("011")
+	c := tiler.readCheckpoint(file, "011", checkpointAssembly, seed)
+	var read Assembly
+	tiler.openRowLog(file+".rows", seed, c, func(row []*Tile) { read = append(read, row) }).close()
+	if len(read) != len(rows) || !sameRows(read[1:], rows[1:]) || !sameRows(c.window, window) {
+		t.Fatalf("read %d rows and %d top rows, different from the %d and %d written",
+			len(read), len(c.window), len(rows), len(window))
+	}
+	if info, err := os.Stat(file + ".rows"); err != nil || info.Size() != c.logSize {
+		t.Errorf("the row log wasn't cut back to the %d bytes the checkpoint has", c.logSize)
+	}
+	for x, tile := range read[0] {
+		if tile != seed[x] {
+			t.Errorf("seed tile %d read as %q, not the new seed row's", x, tile.Name)
+		}
+	}
+}
+
+func TestCheckpointRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		def   string
+		edit  func(b []byte) []byte
+		kind  int
+	}{
+		{"as saved", "011", invertMachine, nil, checkpointSimulation},
+		{"different machine", "011", strings.Replace(invertMachine, "done", "finished", -1), nil, checkpointSimulation},
+		{"different input", "010", invertMachine, nil, checkpointSimulation},
+		{"different version", "011", invertMachine, func(b []byte) []byte {
+			b[len(checkpointMagic)] = checkpointVersion + 1
+			return b
+		}, checkpointSimulation},
+		{"different kind", "011", invertMachine, nil, checkpointAssembly},
+		{"not a checkpoint", "011", invertMachine, func(b []byte) []byte { return []byte("PNG") }, checkpointSimulation},
+		{"truncated", "011", invertMachine, func(b []byte) []byte { return b[:len(b)-2] }, checkpointSimulation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "invert.checkpoint")
+			saver := newCheckpointTiler(t, dir, invertMachine)
+			s := saver.NewSimulator("011")
+			s.Seek(2)
+			saver.saveSimulation(s, file, "011")
+			if tt.edit != nil {
+				b, err := ioutil.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(file, tt.edit(b), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			reader := newCheckpointTiler(t, dir, tt.def)
+			wantPanic := tt.name != "as saved"
+			if panicked := panics(func() { reader.readCheckpoint(file, tt.input, tt.kind, nil) }); panicked != wantPanic {
+				t.Errorf("readCheckpoint panicked %v, want %v", panicked, wantPanic)
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	// an image, as the run names it
+	image := func(tiler *Tiler, suffix string) string {
+		b, err := ioutil.ReadFile(tiler.outputFile("011", suffix, "png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("%x", sha256.Sum256(b))
+	}
+	tests := []struct {
+		name      string
+		kind      string
+		run       func(tiler *Tiler) string
+		interrupt func(tiler *Tiler, file string)
+	}{
+		{"simulation", "-sim", func(tiler *Tiler) string {
+			var w bytes.Buffer
+			halted := tiler.WriteSimulation(&w, "011", 0, false, true)
+			lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+			return fmt.Sprintf("halted %v: %s", halted, lines[len(lines)-1])
+		}, func(tiler *Tiler, file string) {
+			s := tiler.NewSimulator("011")
+			s.Seek(3)
+			tiler.saveSimulation(s, file, "011")
+		}},
+		{"assembly", "-assembly", func(tiler *Tiler) string {
+			var assembly Assembly
+			completed := tiler.assembleCheckpointed("011", func(row []*Tile) { assembly = append(assembly, row) })
+			return fmt.Sprintf("completed %v:\n%s", completed, tileNames(assembly))
+		}, func(tiler *Tiler, file string) {
+			saveAssembly(tiler, file, "011")
+		}},
+		{"stream", "-assembly", func(tiler *Tiler) string {
+			tiler.Stream, tiler.FlipVertical = true, true
+			outcome := tiler.streamOne("011")
+			return fmt.Sprintf("%+v %s", outcome, image(tiler, ""))
+		}, func(tiler *Tiler, file string) {
+			saveAssembly(tiler, file, "011")
+		}},
+		{"compact", "-assembly", func(tiler *Tiler) string {
+			tiler.Compact = "assembler"
+			outcome := tiler.compactOne("011")
+			return fmt.Sprintf("%+v %s", outcome, image(tiler, "-compact"))
+		}, func(tiler *Tiler, file string) {
+			saveAssembly(tiler, file, "011")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tiler := newCheckpointTiler(t, dir, invertMachine)
+			want := tt.run(tiler)
+
+			file := tiler.outputFile("011", tt.kind, "checkpoint")
+			tt.interrupt(tiler, file)
+			// resumed as a new process would, with its tiles in another order
+			tiler = newCheckpointTiler(t, dir, invertMachine)
+			tiler.Resume = true
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			got := tt.run(tiler)
+			log.SetOutput(os.Stderr)
+			if !strings.Contains(logged.String(), "Resuming from") {
+				t.Errorf("the run didn't resume from %s", file)
+			}
+			if got != want {
+				t.Errorf("resumed run gave\n%s\nwant\n%s", got, want)
+			}
+			for _, left := range []string{file, file + ".rows"} {
+				if _, err := os.Stat(left); !os.IsNotExist(err) {
+					t.Errorf("%s left behind after the run ended", left)
+				}
+			}
+		})
+	}
+}
+
+func tileNames(assembly Assembly) string {
+	var lines []string
+	for _, row := range assembly {
+		var names []string
+		for _, tile := range row {
+			if tile == nil {
+				names = append(names, "-")
+			} else {
+				names = append(names, tile.Name)
+			}
+		}
+		lines = append(lines, strings.Join(names, " | "))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,129 @@
+package tiler
+
+import (
+	"image/color"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// compactImage streams a space-time diagram with one pixel per tape cell and
+// one row per step, so that runs far too long to draw as tiles can still be
+// seen whole. each row is written out as it's produced, so only the current one
+// is kept. time runs down the image.
+type compactImage struct {
+	t    *Tiler
+	out  *pngStream
+	line []byte
+}
+
+func (t *Tiler) newCompactImage(file string, width int) *compactImage {
+	log.Printf("Streaming image %s...", file)
+	return &compactImage{
+		t:    t,
+		out:  newPNGStream(file, width),
+		line: make([]byte, 4*width),
+	}
+}
+
+func (c *compactImage) addRow(conf *Config) {
+	for i := 0; i < c.out.width; i++ {
+		col := c.t.cellColor(conf, i)
+		copy(c.line[4*i:], []byte{col.R, col.G, col.B, col.A})
+	}
+	c.out.writeLine(c.line)
+}
+
+// tape cells take the color of their symbol's vertical bond, so they match the
+// tiles. the head takes a brightened color of its state's horizontal bond so
+// that its trajectory stands out, and a halted head the final tile background.
+func (t *Tiler) cellColor(conf *Config, i int) color.RGBA {
+	switch {
+	case i >= len(conf.Tape) || conf.Tape[i] == 0:
+		return t.backgroundColor(false)
+	case i == conf.Head && conf.Halted:
+		return t.backgroundColor(true)
+	case i == conf.Head:
+		return brighten(t.bondColor(Left, Bond{1, conf.State}))
+	}
+	return t.bondColor(Up, Bond{1, string(conf.Tape[i])})
+}
+
+// the color a single tile is reduced to when it's too small to draw
+func (t *Tiler) tileColor(tile *Tile) color.RGBA {
+	return t.cellColor(tileRowConfig([]*Tile{tile}), 0)
+}
+
+// scale a color up so that its brightest channel is saturated
+func brighten(c color.RGBA) color.RGBA {
+	max := c.R
+	if c.G > max {
+		max = c.G
+	}
+	if c.B > max {
+		max = c.B
+	}
+	if max == 0 {
+		return color.RGBA{255, 255, 255, 255}
+	}
+	scale := func(v uint8) uint8 { return uint8(int(v) * 255 / int(max)) }
+	return color.RGBA{scale(c.R), scale(c.G), scale(c.B), 255}
+}
+
+// recover the configuration left behind by a completed row of tiles from
+// their top bonds. cells with no tile are left as zero.
+func tileRowConfig(row []*Tile) *Config {
+	conf := Config{Tape: make([]rune, len(row)), Head: -1}
+	for i, tile := range row {
+		c := tileTextCell(tile)
+		if c == nil {
+			continue
+		}
+		conf.Tape[i], _ = utf8.DecodeRuneInString(c.symbol)
+		if c.head {
+			conf.Head, conf.State = i, c.state
+		}
+		if c.final {
+			conf.Head, conf.Halted = i, true
+			if n := strings.Index(c.symbol, " ["); n >= 0 {
+				conf.Output = strings.TrimSuffix(c.symbol[n+2:], "]")
+			}
+		}
+	}
+	return &conf
+}
+
+// draw a compact space-time diagram for a single input, streaming rows from
+// either the simulator or the assembler
+func (t *Tiler) compactOne(input string) Outcome {
+	if t.Compact != "simulator" && t.Compact != "assembler" {
+		log.Panicf("Unknown compact source %q, expected simulator or assembler", t.Compact)
+	}
+	if t.imageFormat() != "png" {
+		log.Panicf("Compact images are streamed, so they can only be png")
+	}
+	file := t.outputFile(input, "-compact", "png")
+	c := t.newCompactImage(file, utf8.RuneCountInString(input)+2)
+	var last Config
+	steps := -1
+	addRow := func(conf *Config) {
+		c.addRow(conf)
+		last = *conf
+		steps++
+	}
+
+	log.Printf("Running %s...", t.Compact)
+	var completed bool
+	if t.Compact == "simulator" {
+		completed = t.simulateRows(input, addRow)
+	} else {
+		completed = t.assembleCheckpointed(input, func(row []*Tile) { addRow(tileRowConfig(row)) })
+	}
+	c.out.close(t.runMeta(input, steps, &last))
+	outcome := t.outcome(input, steps, &last, completed)
+	if !completed && !t.IgnoreDepthFailure {
+		os.Remove(file)
+	}
+	return outcome
+}
@@ -0,0 +1,85 @@
+package tiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the config file for every machine in a directory
+	DirConfigFile = "turing-tiler.json"
+	// environment variables named this and a flag's name in capitals, with
+	// underscores for dashes, override config files
+	ConfigEnvPrefix = "TURING_TILER_"
+)
+
+// ConfigFiles lists the config files which exist for a machine, least
+// specific first: the directory's, then the machine's own, named after it with
+// a .json extension
+func ConfigFiles(machineFile string) []string {
+	var files []string
+	for _, file := range []string{
+		filepath.Join(filepath.Dir(machineFile), DirConfigFile),
+		strings.TrimSuffix(machineFile, filepath.Ext(machineFile)) + ".json",
+	} {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// LoadConfig reads config files, each a JSON object of flag names and values
+// such as {"flip-vertical": true, "tile-width": 40}, with later files taking
+// precedence. lists are joined with commas. it returns the values as they'd be
+// given on the command line, and the file each came from.
+func LoadConfig(files []string) (values, sources map[string]string) {
+	values = make(map[string]string)
+	sources = make(map[string]string)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Panicf("Couldn't read %s: %s", file, err)
+		}
+		var config map[string]interface{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			log.Panicf("Couldn't parse %s: %s", file, err)
+		}
+		for name, value := range config {
+			values[name] = configValue(file, name, value)
+			sources[name] = file
+		}
+	}
+	return values, sources
+}
+
+func configValue(file, name string, value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return fmt.Sprint(v)
+	case float64:
+		// not in exponent form, which integer flags don't take
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			items = append(items, configValue(file, name, item))
+		}
+		return strings.Join(items, ",")
+	}
+	log.Panicf("%s: %s should be a string, number, boolean or list, not %v", file, name, value)
+	return ""
+}
+
+// ConfigEnv is the environment variable which can set a flag
+func ConfigEnv(name string) string {
+	return ConfigEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
@@ -0,0 +1,38 @@
+package tiler
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"large integer", `{"max-depth": 1000000}`, "1000000"},
+		{"huge integer", `{"max-depth": 1e21}`, "1000000000000000000000"},
+		{"fraction", `{"max-depth": 9.25}`, "9.25"},
+		{"negative", `{"max-depth": -3}`, "-3"},
+		{"boolean", `{"max-depth": true}`, "true"},
+		{"string", `{"max-depth": "1e6"}`, "1e6"},
+		{"list", `{"max-depth": [1, "b", 2.5]}`, "1,b,2.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "inv.json")
+			if err := ioutil.WriteFile(file, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			values, sources := LoadConfig([]string{file})
+			if values["max-depth"] != tt.want {
+				t.Errorf("max-depth is %q, want %q", values["max-depth"], tt.want)
+			}
+			if sources["max-depth"] != file {
+				t.Errorf("max-depth came from %q, want %q", sources["max-depth"], file)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package tiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// a breakpoint stops a run when the machine enters a state, is about to apply
+// the transition for a state and symbol, or has its head on a cell
+type breakpoint struct {
+	kind          string // "state", "trans" or "head"
+	state, symbol string
+	head          int
+}
+
+func (b *breakpoint) String() string {
+	switch b.kind {
+	case "state":
+		return "state " + b.state
+	case "trans":
+		return fmt.Sprintf("trans %s %s", b.state, b.symbol)
+	}
+	return fmt.Sprintf("head %d", b.head)
+}
+
+// hit says whether the step the simulator just took, from state from, stops
+// at the breakpoint. a state breakpoint isn't hit by staying in the state.
+func (b *breakpoint) hit(s *Simulator, from string) bool {
+	switch b.kind {
+	case "state":
+		return s.State == b.state && from != b.state
+	case "trans":
+		return s.State == b.state && s.Head < len(s.Tape) && string(s.Tape[s.Head]) == b.symbol
+	}
+	return s.Head == b.head
+}
+
+// a configuration saved before an edit, to go back to. steps are undone by
+// the simulator's own history instead.
+type debugSnapshot struct {
+	Config
+	Steps int
+}
+
+type debugger struct {
+	*Tiler
+	out     io.Writer
+	input   string
+	sim     *Simulator
+	history []*debugSnapshot // each edit, or nil for each step, in order
+	breaks  []*breakpoint
+	window  int // cells shown either side of the head
+}
+
+const debugHelp = `commands:
+  step [n]                step forward n times (s)
+  back [n]                undo the last n steps or edits (b)
+  goto <step>             go back or forward to a step number (g)
+  continue                run until a breakpoint, halt or stall, at most MaxDepth steps (c)
+  break state <state>     stop on entering a state
+  break trans <state> <symbol>
+                          stop before the transition for a state and symbol
+  break head <cell>       stop when the head is on a cell, the left boundary being 0
+  breaks                  list breakpoints
+  written <cell>          say which step last wrote to a cell (w)
+  delete <n>|all          remove breakpoint n, or all of them
+  print                   show the tape around the head (p)
+  window <n>              show n cells either side of the head
+  set tape <cell> <symbols>
+                          overwrite cells starting at one
+  set state <state>       change the state, resuming a halted machine
+  set head <cell>         move the head
+  restart                 start again from the input
+  help                    show this (h)
+  quit                    stop debugging (q)
+an empty line repeats the last command
+`
+
+// Debug runs an interactive debugger on the simulator for an input, reading
+// commands from in and writing to out until quit or the end of in. window is
+// how many cells either side of the head are shown.
+func (t *Tiler) Debug(input string, window int, in io.Reader, out io.Writer) {
+	if !t.validInput(input) {
+		fmt.Fprintf(out, "invalid symbol in input %q\n", input)
+		return
+	}
+	d := debugger{Tiler: t, out: out, input: input, window: window}
+	d.restart()
+	fmt.Fprintf(out, "debugging %s on %q; type help for commands\n", t.Name, input)
+	d.print()
+
+	scanner := bufio.NewScanner(in)
+	var last []string
+	for {
+		fmt.Fprint(out, "(debug) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			fields = last
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		last = fields
+		if fields[0] == "quit" || fields[0] == "q" {
+			return
+		}
+		if err := d.command(fields[0], fields[1:]); err != nil {
+			fmt.Fprintln(out, err)
+		}
+	}
+}
+
+func (d *debugger) restart() {
+	d.sim = d.NewSimulator(d.input)
+	d.sim.Record()
+	d.history = nil
+}
+
+// debugCount parses an optional repeat count
+func debugCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("expected a count, not %q", args[0])
+	}
+	return n, nil
+}
+
+// cell parses a tape position
+func (d *debugger) cell(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 || n >= len(d.sim.Tape) {
+		return 0, fmt.Errorf("expected a cell from 0 to %d, not %q", len(d.sim.Tape)-1, arg)
+	}
+	return n, nil
+}
+
+func (d *debugger) command(name string, args []string) error {
+	switch name {
+	case "step", "s":
+		n, err := debugCount(args)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			if !d.step() {
+				break
+			}
+		}
+		d.print()
+	case "back", "b":
+		n, err := debugCount(args)
+		if err != nil {
+			return err
+		}
+		if len(d.history) == 0 {
+			return fmt.Errorf("already at the start")
+		}
+		for ; n > 0 && d.back(); n-- {
+		}
+		d.print()
+	case "goto", "g":
+		n, err := strconv.Atoi(strings.Join(args, ""))
+		if err != nil || len(args) != 1 || n < 0 {
+			return fmt.Errorf("usage: goto <step>")
+		}
+		for d.sim.Steps > n && d.back() {
+		}
+		for d.sim.Steps < n && d.step() {
+		}
+		d.print()
+	case "written", "w":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: written <cell>")
+		}
+		n, err := d.cell(args[0])
+		if err != nil {
+			return err
+		}
+		if record, step := d.sim.LastWrite(n); record != nil {
+			fmt.Fprintf(d.out, "cell %d was last written at step %d, from %s to %s by %s\n",
+				n, step, string(record.Overwrote), record.Transition.WriteSymbol, record.Transition)
+		} else {
+			fmt.Fprintf(d.out, "cell %d hasn't been written by a step\n", n)
+		}
+	case "continue", "c":
+		d.run()
+	case "break":
+		return d.addBreak(args)
+	case "breaks":
+		if len(d.breaks) == 0 {
+			fmt.Fprintln(d.out, "no breakpoints")
+		}
+		for i, b := range d.breaks {
+			fmt.Fprintf(d.out, "%d: %s\n", i+1, b)
+		}
+	case "delete":
+		if len(args) == 1 && args[0] == "all" {
+			d.breaks = nil
+			return nil
+		}
+		n, err := debugCount(args)
+		if err != nil || len(args) != 1 || n > len(d.breaks) {
+			return fmt.Errorf("usage: delete <n>|all, where n is listed by breaks")
+		}
+		d.breaks = append(d.breaks[:n-1], d.breaks[n:]...)
+	case "print", "p":
+		d.print()
+	case "window":
+		n, err := debugCount(args)
+		if err != nil || len(args) != 1 {
+			return fmt.Errorf("usage: window <n>")
+		}
+		d.window = n
+		d.print()
+	case "set":
+		if err := d.set(args); err != nil {
+			return err
+		}
+		d.print()
+	case "restart":
+		d.restart()
+		d.print()
+	case "help", "h":
+		io.WriteString(d.out, debugHelp)
+	default:
+		return fmt.Errorf("unknown command %q; type help for commands", name)
+	}
+	return nil
+}
+
+// step applies one transition, saving the configuration before it. it
+// returns false if the machine can't step, saying why unless it has halted.
+func (d *debugger) step() bool {
+	s := d.sim
+	if s.Halted {
+		return false
+	}
+	if !s.Step() {
+		if s.Transition() == nil {
+			fmt.Fprintf(d.out, "stalled: no transition for state %s on %s\n", s.State, d.symbol())
+		} else {
+			fmt.Fprintln(d.out, "stalled: off the edge of the tape")
+		}
+		return false
+	}
+	d.history = append(d.history, nil)
+	return true
+}
+
+// back undoes the last step or edit, returning false if there is none
+func (d *debugger) back() bool {
+	if len(d.history) == 0 {
+		return false
+	}
+	last := d.history[len(d.history)-1]
+	d.history = d.history[:len(d.history)-1]
+	if last == nil {
+		d.sim.Back()
+	} else {
+		d.restore(*last)
+	}
+	return true
+}
+
+// run steps until a breakpoint is hit or the machine stops, giving up after
+// MaxDepth steps
+func (d *debugger) run() {
+	for n := 0; d.MaxDepth <= 0 || n < d.MaxDepth; n++ {
+		from := d.sim.State
+		if !d.step() {
+			d.print()
+			return
+		}
+		for i, b := range d.breaks {
+			if b.hit(d.sim, from) {
+				fmt.Fprintf(d.out, "breakpoint %d: %s\n", i+1, b)
+				d.print()
+				return
+			}
+		}
+	}
+	fmt.Fprintf(d.out, "still running after %d steps\n", d.MaxDepth)
+	d.print()
+}
+
+func (d *debugger) addBreak(args []string) error {
+	b := breakpoint{}
+	switch {
+	case len(args) == 2 && args[0] == "state":
+		b = breakpoint{kind: "state", state: args[1]}
+	case len(args) == 3 && args[0] == "trans":
+		if d.findTransition(args[1], args[2]) == nil {
+			fmt.Fprintf(d.out, "warning: there is no transition for state %s on %s\n", args[1], args[2])
+		}
+		b = breakpoint{kind: "trans", state: args[1], symbol: args[2]}
+	case len(args) == 2 && args[0] == "head":
+		n, err := d.cell(args[1])
+		if err != nil {
+			return err
+		}
+		b = breakpoint{kind: "head", head: n}
+	default:
+		return fmt.Errorf("usage: break state <state> | break trans <state> <symbol> | break head <cell>")
+	}
+	d.breaks = append(d.breaks, &b)
+	fmt.Fprintf(d.out, "%d: %s\n", len(d.breaks), &b)
+	return nil
+}
+
+// set edits the configuration, which can be undone with back like a step
+func (d *debugger) set(args []string) error {
+	s := d.sim
+	switch {
+	case len(args) == 3 && args[0] == "tape":
+		n, err := d.cell(args[1])
+		if err != nil {
+			return err
+		}
+		symbols := []rune(args[2])
+		if n+len(symbols) > len(s.Tape) {
+			return fmt.Errorf("%q doesn't fit on the tape from cell %d", args[2], n)
+		}
+		for _, r := range symbols {
+			if !strings.ContainsRune(string(d.Symbols), r) {
+				return fmt.Errorf("%s isn't a symbol of %s", string(r), d.Name)
+			}
+		}
+		d.save()
+		copy(s.Tape[n:], symbols)
+	case len(args) == 2 && args[0] == "state":
+		d.save()
+		s.State = args[1]
+		s.Halted, s.Output = false, ""
+	case len(args) == 2 && args[0] == "head":
+		n, err := d.cell(args[1])
+		if err != nil {
+			return err
+		}
+		d.save()
+		s.Head = n
+	default:
+		return fmt.Errorf("usage: set tape <cell> <symbols> | set state <state> | set head <cell>")
+	}
+	return nil
+}
+
+func (d *debugger) save() {
+	s := d.sim
+	snapshot := debugSnapshot{Config: s.Config, Steps: s.Steps}
+	snapshot.Tape = append([]rune(nil), s.Tape...)
+	d.history = append(d.history, &snapshot)
+}
+
+func (d *debugger) restore(snapshot debugSnapshot) {
+	d.sim.Config = snapshot.Config
+	d.sim.Steps = snapshot.Steps
+}
+
+// the symbol under the head
+func (d *debugger) symbol() string {
+	return string(d.sim.Tape[d.sim.Head])
+}
+
+// print shows the step, state and head position, then the cells around the
+// head with a caret under it, as WriteSimulation does, and the transition the
+// next step would apply
+func (d *debugger) print() {
+	s := d.sim
+	from, to := s.Head-d.window, s.Head+d.window+1
+	left, right := "", ""
+	if from <= 0 {
+		from = 0
+	} else {
+		left = "…"
+	}
+	if to >= len(s.Tape) {
+		to = len(s.Tape)
+	} else {
+		right = "…"
+	}
+	prefix := fmt.Sprintf("%4d (%2s/%2d) %s", s.Steps, s.State, s.Head, left)
+	fmt.Fprintf(d.out, "%s%s%s\n", prefix, string(s.Tape[from:to]), right)
+	fmt.Fprintf(d.out, "%s^\n", strings.Repeat(" ", utf8.RuneCountInString(prefix)+s.Head-from))
+	switch trans := s.Transition(); {
+	case s.Halted:
+		fmt.Fprintf(d.out, "halted with output %q\n", s.Output)
+	case trans == nil:
+		fmt.Fprintf(d.out, "no transition for state %s on %s\n", s.State, d.symbol())
+	default:
+		fmt.Fprintf(d.out, "next: %s\n", trans)
+	}
+}
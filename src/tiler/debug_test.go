@@ -0,0 +1,78 @@
+package tiler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// a debugger of invertMachine on 011
+func newInvertDebugger(t *testing.T) (*debugger, *bytes.Buffer) {
+	tiler := &Tiler{Options: Options{BoundarySymbol: '*', MaxDepth: 100}}
+	tiler.Machine = tiler.parseMachine(strings.NewReader(invertMachine), "invert")
+	var out bytes.Buffer
+	d := &debugger{Tiler: tiler, out: &out, input: "011", window: 2}
+	d.restart()
+	return d, &out
+}
+
+func TestDebugCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []string
+		wantStep int
+		wantOut  string // printed by the last command
+	}{
+		{"break on entering a state", []string{"break state 2", "continue"}, 4, "breakpoint 1: state 2"},
+		{"no break for staying in a state", []string{"break state 1", "continue"}, 5, "halted"},
+		{"break before a transition", []string{"break trans 1 *", "continue"}, 3, "breakpoint 1: trans 1 *"},
+		{"break on a cell", []string{"break head 3", "continue"}, 2, "breakpoint 1: head 3"},
+		{"second breakpoint", []string{"break head 4", "break state 2", "continue", "continue"}, 4, "breakpoint 2: state 2"},
+		{"deleted breakpoint", []string{"break state 2", "delete 1", "continue"}, 5, "halted"},
+		{"all deleted", []string{"break state 2", "break head 2", "delete all", "breaks"}, 0, "no breakpoints"},
+		{"delete a missing breakpoint", []string{"break state 2", "delete 2"}, 0, "usage: delete"},
+		{"set then back", []string{"step", "set tape 1 0", "set head 3", "back 2"}, 1, "next: 1 1 0 r 1"},
+		{"back past an edit", []string{"step 2", "set state 2", "step", "back 3"}, 1, ""},
+		{"goto back across edits", []string{"step 2", "set state 2", "step", "goto 1"}, 1, ""},
+		{"goto forward after going back", []string{"step 2", "set tape 1 0", "goto 0", "goto 5"}, 5, "halted"},
+		{"back at the start", []string{"back"}, 0, "already at the start"},
+		{"restart", []string{"step 3", "set state 2", "restart"}, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, out := newInvertDebugger(t)
+			for _, c := range tt.commands {
+				out.Reset()
+				fields := strings.Fields(c)
+				if err := d.command(fields[0], fields[1:]); err != nil {
+					out.WriteString(err.Error())
+				}
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("%q printed\n%s\nwant it to contain %q", tt.commands[len(tt.commands)-1], out, tt.wantOut)
+			}
+			checkConfig(t, d.sim, tt.wantStep)
+		})
+	}
+}
+
+func TestDebug(t *testing.T) {
+	tiler := &Tiler{Options: Options{BoundarySymbol: '*', MaxDepth: 100}}
+	tiler.Machine = tiler.parseMachine(strings.NewReader(invertMachine), "invert")
+	var out bytes.Buffer
+	// the empty line repeats the step, and nothing after quit is run
+	tiler.Debug("011", 2, strings.NewReader("step\n\nbogus\nquit\nstep\n"), &out)
+	got := out.String()
+	for _, want := range []string{
+		"debugging invert on \"011\"",
+		"   2 ( 1/ 3) …101*",
+		"unknown command \"bogus\"",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("the session printed\n%s\nwant it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "   3 ") {
+		t.Errorf("the session went on after quit:\n%s", got)
+	}
+}
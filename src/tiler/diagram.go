@@ -0,0 +1,519 @@
+package tiler
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// a state diagram of a machine: states are nodes and transitions are edges
+type StateGraph struct {
+	States  []string // the initial state first, then the rest sorted
+	Initial string
+	Edges   []*StateEdge
+}
+
+// one or more transitions between the same pair of states with the same move.
+// halting edges also share the same output.
+type StateEdge struct {
+	From, To      string
+	Move          Direction
+	Output        string
+	Reads, Writes []string
+}
+
+// StateGraph builds the diagram of the machine's transitions. with concise set
+// parallel edges are merged into one, as machine2png.pl --concise does.
+func (m *Machine) StateGraph(concise bool) *StateGraph {
+	g := StateGraph{Initial: m.InitialState}
+
+	seen := map[string]bool{m.InitialState: true}
+	var others []string
+	type edgeKey struct {
+		from, to string
+		move     Direction
+		output   string
+	}
+	merged := make(map[edgeKey]*StateEdge)
+	for _, trans := range m.Transitions {
+		for _, state := range []string{trans.OldState, trans.NewState} {
+			if !seen[state] {
+				seen[state] = true
+				others = append(others, state)
+			}
+		}
+
+		key := edgeKey{trans.OldState, trans.NewState, trans.Move, trans.Output}
+		e, exists := merged[key]
+		if !exists || !concise {
+			e = &StateEdge{From: key.from, To: key.to, Move: key.move, Output: key.output}
+			merged[key] = e
+			g.Edges = append(g.Edges, e)
+		}
+		e.Reads = append(e.Reads, trans.ReadSymbol)
+		e.Writes = append(e.Writes, trans.WriteSymbol)
+	}
+	sort.Strings(others)
+	g.States = append([]string{m.InitialState}, others...)
+	return &g
+}
+
+// Label describes an edge as "read,write:move", or "[reads],[writes]:move" for
+// merged edges, where move is an arrow or the halting output
+func (e *StateEdge) Label() string {
+	move := e.Output
+	switch e.Move {
+	case Left:
+		move = "←"
+	case Right:
+		move = "→"
+	}
+	if len(e.Reads) == 1 {
+		return fmt.Sprintf("%s,%s:%s", e.Reads[0], e.Writes[0], move)
+	}
+
+	// keep reads and writes paired while sorting by the read symbol
+	idx := make([]int, len(e.Reads))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return e.Reads[idx[a]] < e.Reads[idx[b]] })
+	var reads, writes string
+	for _, i := range idx {
+		reads += e.Reads[i]
+		writes += e.Writes[i]
+	}
+	return fmt.Sprintf("[%s],[%s]:%s", reads, writes, move)
+}
+
+func (e *StateEdge) Halting() bool {
+	return e.Move == Halt
+}
+
+// spacing of the layered layout, in pixels
+const (
+	diagramLayerGap = 140
+	diagramNodeGap  = 80
+	diagramRadius   = 18
+	diagramMargin   = 60
+	diagramFontSize = 11
+)
+
+// Layout places states in layers by their distance from the initial state,
+// left to right, returning the center of each state and the overall size
+func (g *StateGraph) Layout() (map[string][2]float64, float64, float64) {
+	next := make(map[string][]string)
+	for _, e := range g.Edges {
+		next[e.From] = append(next[e.From], e.To)
+	}
+
+	// breadth first from the initial state; unreachable states go last
+	layerOf := map[string]int{g.Initial: 0}
+	queue := []string{g.Initial}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for _, n := range next[s] {
+			if _, exists := layerOf[n]; !exists {
+				layerOf[n] = layerOf[s] + 1
+				queue = append(queue, n)
+			}
+		}
+	}
+	last := 0
+	for _, l := range layerOf {
+		if l > last {
+			last = l
+		}
+	}
+	var layers [][]string
+	for _, s := range g.States {
+		l, exists := layerOf[s]
+		if !exists {
+			l = last + 1
+		}
+		for len(layers) <= l {
+			layers = append(layers, nil)
+		}
+		layers[l] = append(layers[l], s)
+	}
+
+	tallest := 0
+	for _, layer := range layers {
+		if len(layer) > tallest {
+			tallest = len(layer)
+		}
+	}
+	// self loops stack upward, so leave room above for the most of them
+	// and to the sides for their labels, which are centered over the state.
+	// svg has no font metrics to go on, so widths are estimated.
+	loops := make(map[string]int)
+	most := 0
+	side := float64(diagramMargin)
+	for _, e := range g.Edges {
+		if e.From == e.To {
+			loops[e.From]++
+			if loops[e.From] > most {
+				most = loops[e.From]
+			}
+			half := 0.3 * diagramFontSize * float64(utf8.RuneCountInString(e.Label()))
+			side = math.Max(side, half+4)
+		}
+	}
+	top := float64(diagramMargin)
+	if most > 0 {
+		size := float64(diagramRadius) * (1.6 + 0.6*float64(most-1))
+		top = math.Max(top, diagramRadius+2*size+diagramFontSize+4)
+	}
+
+	width := side*2 + float64(diagramLayerGap*(len(layers)-1))
+	height := top + float64(diagramMargin+diagramNodeGap*(tallest-1))
+
+	pos := make(map[string][2]float64)
+	for l, layer := range layers {
+		// center each layer vertically
+		first := top + float64(diagramNodeGap*(tallest-len(layer)))/2
+		for i, s := range layer {
+			pos[s] = [2]float64{
+				side + float64(diagramLayerGap*l),
+				first + float64(diagramNodeGap*i),
+			}
+		}
+	}
+	return pos, width, height
+}
+
+// the geometry of an edge once the diagram is laid out
+type edgeShape struct {
+	*StateEdge
+	curve          [][2]float64 // bezier control points from the tail to the head
+	labelX, labelY float64      // where the label's baseline is centered
+}
+
+// route the edges of a laid out diagram. edges between the same states bow out
+// by different amounts so that they don't overlap; edges going opposite ways
+// bow to opposite sides.
+func (g *StateGraph) route(pos map[string][2]float64) []edgeShape {
+	var shapes []edgeShape
+	bows := make(map[[2]string]int)
+	for _, e := range g.Edges {
+		p1, p2 := pos[e.From], pos[e.To]
+
+		if e.From == e.To {
+			n := bows[[2]string{e.From, e.To}]
+			bows[[2]string{e.From, e.To}]++
+			size := float64(diagramRadius) * (1.6 + 0.6*float64(n))
+			x, y := p1[0], p1[1]-diagramRadius
+			shapes = append(shapes, edgeShape{
+				StateEdge: e,
+				curve:     [][2]float64{{x - 8, y + 2}, {x - size, y - 2*size}, {x + size, y - 2*size}, {x + 8, y + 2}},
+				labelX:    x,
+				labelY:    y - 1.5*size - 2,
+			})
+			continue
+		}
+
+		key := [2]string{e.From, e.To}
+		if e.To < e.From {
+			key = [2]string{e.To, e.From}
+		}
+		n := bows[key]
+		bows[key]++
+		dx, dy := p2[0]-p1[0], p2[1]-p1[1]
+		d := math.Hypot(dx, dy)
+		ux, uy := dx/d, dy/d
+		bow := 20 * float64(n+1)
+		// control point off the midpoint, perpendicular to the edge
+		c := [2]float64{(p1[0]+p2[0])/2 - uy*bow, (p1[1]+p2[1])/2 + ux*bow}
+		sx, sy := shorten(p1, c, diagramRadius)
+		ex, ey := shorten(p2, c, diagramRadius)
+		// the curve passes halfway between the midpoint and control point
+		shapes = append(shapes, edgeShape{
+			StateEdge: e,
+			curve:     [][2]float64{{sx, sy}, c, {ex, ey}},
+			labelX:    (p1[0] + p2[0] + 2*c[0]) / 4,
+			labelY:    (p1[1]+p2[1]+2*c[1])/4 - 3,
+		})
+	}
+	return shapes
+}
+
+func (e *StateEdge) color() string {
+	if e.Halting() {
+		return "red"
+	}
+	return "black"
+}
+
+// WriteSVG draws the diagram. the initial state is filled in black and halting
+// edges are red, as in machine2png.pl. nodes carry a data-state attribute so
+// that pages embedding the diagram can highlight them.
+func (g *StateGraph) WriteSVG(w io.Writer) {
+	pos, width, height := g.Layout()
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" class="diagram" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="sans-serif" font-size="%d">`+"\n",
+		width, height, width, height, diagramFontSize)
+	fmt.Fprint(w, `<defs>`)
+	for _, color := range []string{"black", "red"} {
+		fmt.Fprintf(w, `<marker id="arrow-%s" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="8" markerHeight="8" orient="auto"><path d="M0,0 L10,5 L0,10 z" fill="%s"/></marker>`, color, color)
+	}
+	fmt.Fprint(w, "</defs>\n")
+
+	for _, e := range g.route(pos) {
+		color := e.color()
+		c := e.curve
+		var d string
+		if len(c) == 4 {
+			d = fmt.Sprintf("M%.1f,%.1f C%.1f,%.1f %.1f,%.1f %.1f,%.1f", c[0][0], c[0][1], c[1][0], c[1][1], c[2][0], c[2][1], c[3][0], c[3][1])
+		} else {
+			d = fmt.Sprintf("M%.1f,%.1f Q%.1f,%.1f %.1f,%.1f", c[0][0], c[0][1], c[1][0], c[1][1], c[2][0], c[2][1])
+		}
+		fmt.Fprintf(w, `<path d="%s" fill="none" stroke="%s" marker-end="url(#arrow-%s)"/>`, d, color, color)
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" text-anchor="middle" fill="%s" paint-order="stroke" stroke="white" stroke-width="3">%s</text>`+"\n",
+			e.labelX, e.labelY, color, html.EscapeString(e.Label()))
+	}
+
+	for _, s := range g.States {
+		fill, text := "white", "black"
+		if s == g.Initial {
+			fill, text = "black", "white"
+		}
+		p := pos[s]
+		name := html.EscapeString(s)
+		fmt.Fprintf(w, `<g data-state="%s"><circle cx="%.1f" cy="%.1f" r="%d" fill="%s" stroke="black"/><text x="%.1f" y="%.1f" text-anchor="middle" fill="%s">%s</text></g>`+"\n",
+			name, p[0], p[1], diagramRadius, fill, p[0], p[1]+4, text, name)
+	}
+	fmt.Fprintln(w, "</svg>")
+}
+
+// WriteDOT writes the diagram as graphviz input in the same form machine2png.pl
+// generates, for anyone who'd rather have dot lay it out
+func (g *StateGraph) WriteDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph machine {")
+	for _, s := range g.States {
+		if s == g.Initial {
+			fmt.Fprintf(w, "    %s [label=%s, style=filled, fillcolor=black, fontcolor=white]\n", dotID(s), dotQuote(s))
+		} else {
+			fmt.Fprintf(w, "    %s [label=%s]\n", dotID(s), dotQuote(s))
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "    %s->%s [label=%s,color=%s]\n", dotID(e.From), dotID(e.To), dotQuote(e.Label()), e.color())
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// states are prefixed as in machine2png.pl, but quoted since they may hold
+// characters dot doesn't allow in bare identifiers
+func dotID(state string) string {
+	return dotQuote("S" + state)
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// the point at distance r from a toward b
+func shorten(a, b [2]float64, r float64) (float64, float64) {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	d := math.Hypot(dx, dy)
+	if d == 0 {
+		return a[0], a[1]
+	}
+	return a[0] + dx/d*r, a[1] + dy/d*r
+}
+
+// diagramImage rasterizes the same drawing WriteSVG produces, so that a png
+// can be made without graphviz or a browser
+func (t *Tiler) diagramImage(g *StateGraph) *image.RGBA {
+	pos, width, height := g.Layout()
+	im := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	draw.Draw(im, im.Bounds(), image.White, image.ZP, draw.Src)
+	colors := map[string]color.RGBA{
+		"black": {0, 0, 0, 255},
+		"white": {255, 255, 255, 255},
+		"red":   {255, 0, 0, 255},
+	}
+
+	for _, e := range g.route(pos) {
+		col := colors[e.color()]
+		// the arrowhead points along the curve's final tangent
+		head, prev := e.curve[len(e.curve)-1], e.curve[len(e.curve)-2]
+		dx, dy := head[0]-prev[0], head[1]-prev[1]
+		d := math.Hypot(dx, dy)
+		ux, uy := dx/d, dy/d
+		arrow := [][2]float64{head,
+			{head[0] - 8*ux - 4*uy, head[1] - 8*uy + 4*ux},
+			{head[0] - 8*ux + 4*uy, head[1] - 8*uy - 4*ux}}
+
+		// a curve lies within the hull of its control points
+		bounds := shapeBounds(append(append([][2]float64{}, e.curve...), arrow...)).Intersect(im.Bounds())
+		mask := image.NewAlpha(bounds)
+		var points [][2]float64
+		for i := 0; i <= 32; i++ {
+			points = append(points, bezier(e.curve, float64(i)/32))
+		}
+		for i := 1; i < len(points); i++ {
+			strokeSegment(mask, points[i-1], points[i], 1)
+		}
+		fillTriangle(mask, arrow[0], arrow[1], arrow[2])
+		draw.DrawMask(im, bounds, image.NewUniform(col), image.ZP, mask, bounds.Min, draw.Over)
+
+		label := e.Label()
+		x := int(e.labelX - t.textWidth(label, diagramFontSize)/2)
+		y := int(e.labelY)
+		// a white halo keeps labels readable where they cross edges
+		for _, o := range [][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}} {
+			t.drawText(im, colors["white"], label, diagramFontSize, x+o[0], y+o[1])
+		}
+		t.drawText(im, col, label, diagramFontSize, x, y)
+	}
+
+	for _, s := range g.States {
+		fill, text := colors["white"], colors["black"]
+		if s == g.Initial {
+			fill, text = colors["black"], colors["white"]
+		}
+		p := pos[s]
+		r := float64(diagramRadius)
+		bounds := shapeBounds([][2]float64{{p[0] - r, p[1] - r}, {p[0] + r, p[1] + r}}).Intersect(im.Bounds())
+		disc := image.NewAlpha(bounds)
+		ring := image.NewAlpha(bounds)
+		for y := int(p[1] - r - 2); y <= int(p[1]+r+2); y++ {
+			for x := int(p[0] - r - 2); x <= int(p[0]+r+2); x++ {
+				d := math.Hypot(float64(x)+0.5-p[0], float64(y)+0.5-p[1])
+				disc.SetAlpha(x, y, color.Alpha{coverage(r + 0.5 - d)})
+				ring.SetAlpha(x, y, color.Alpha{coverage(1 - math.Abs(d-r))})
+			}
+		}
+		draw.DrawMask(im, bounds, image.NewUniform(fill), image.ZP, disc, bounds.Min, draw.Over)
+		draw.DrawMask(im, bounds, image.NewUniform(colors["black"]), image.ZP, ring, bounds.Min, draw.Over)
+		t.drawText(im, text, s, diagramFontSize, int(p[0]-t.textWidth(s, diagramFontSize)/2), int(p[1]+4))
+	}
+	return im
+}
+
+// the pixels a shape through points can cover, with room for its
+// antialiasing and the width of a stroke
+func shapeBounds(points [][2]float64) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+	return image.Rect(int(math.Floor(minX))-3, int(math.Floor(minY))-3, int(math.Ceil(maxX))+3, int(math.Ceil(maxY))+3)
+}
+
+// a point along a bezier curve of any degree, by de casteljau's algorithm
+func bezier(points [][2]float64, f float64) [2]float64 {
+	p := append([][2]float64(nil), points...)
+	for n := len(p) - 1; n > 0; n-- {
+		for i := 0; i < n; i++ {
+			p[i] = [2]float64{p[i][0] + (p[i+1][0]-p[i][0])*f, p[i][1] + (p[i+1][1]-p[i][1])*f}
+		}
+	}
+	return p[0]
+}
+
+// the alpha of a pixel whose center is the given distance inside an edge
+func coverage(inside float64) uint8 {
+	switch {
+	case inside <= 0:
+		return 0
+	case inside >= 1:
+		return 255
+	}
+	return uint8(inside * 255)
+}
+
+// add a line of the given width to a mask, antialiased by distance
+func strokeSegment(mask *image.Alpha, a, b [2]float64, width float64) {
+	half := width / 2
+	minX, maxX := math.Min(a[0], b[0])-half-1, math.Max(a[0], b[0])+half+1
+	minY, maxY := math.Min(a[1], b[1])-half-1, math.Max(a[1], b[1])+half+1
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	l := dx*dx + dy*dy
+	for y := int(minY); y <= int(maxY); y++ {
+		for x := int(minX); x <= int(maxX); x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			f := 0.0
+			if l > 0 {
+				f = math.Max(0, math.Min(1, ((px-a[0])*dx+(py-a[1])*dy)/l))
+			}
+			d := math.Hypot(px-a[0]-f*dx, py-a[1]-f*dy)
+			if c := coverage(half + 0.5 - d); c > mask.AlphaAt(x, y).A {
+				mask.SetAlpha(x, y, color.Alpha{c})
+			}
+		}
+	}
+}
+
+// add a filled triangle to a mask, antialiased by sampling each pixel 4x4
+func fillTriangle(mask *image.Alpha, a, b, c [2]float64) {
+	side := func(p, q, r [2]float64) float64 {
+		return (q[0]-p[0])*(r[1]-p[1]) - (q[1]-p[1])*(r[0]-p[0])
+	}
+	minX, maxX := math.Min(a[0], math.Min(b[0], c[0])), math.Max(a[0], math.Max(b[0], c[0]))
+	minY, maxY := math.Min(a[1], math.Min(b[1], c[1])), math.Max(a[1], math.Max(b[1], c[1]))
+	for y := int(minY); y <= int(maxY); y++ {
+		for x := int(minX); x <= int(maxX); x++ {
+			n := 0
+			for sy := 0; sy < 4; sy++ {
+				for sx := 0; sx < 4; sx++ {
+					p := [2]float64{float64(x) + (float64(sx)+0.5)/4, float64(y) + (float64(sy)+0.5)/4}
+					s1, s2, s3 := side(a, b, p), side(b, c, p), side(c, a, p)
+					if (s1 >= 0 && s2 >= 0 && s3 >= 0) || (s1 <= 0 && s2 <= 0 && s3 <= 0) {
+						n++
+					}
+				}
+			}
+			if v := uint8(n * 255 / 16); v > mask.AlphaAt(x, y).A {
+				mask.SetAlpha(x, y, color.Alpha{v})
+			}
+		}
+	}
+}
+
+// WriteDiagram writes the state diagram of MachineFile under OutputDir, named
+// like it with the extension replaced by the format: "dot", "svg" or "png". no
+// tiles are made.
+func (o *Options) WriteDiagram(format string, concise bool) {
+	t := o.NewMachineTiler()
+	g := t.StateGraph(concise)
+
+	switch format {
+	case "dot", "svg", "png":
+	default:
+		log.Panicf("Unknown diagram format %q, expected dot, svg or png", format)
+	}
+	base := filepath.Base(t.MachineFile)
+	file := t.outputPath(strings.TrimSuffix(base, filepath.Ext(base)) + "." + format)
+	if format == "png" {
+		t.setupDrawer()
+		t.saveImage(file, t.diagramImage(g), imageMeta{"Software", "turing-tiler"}, imageMeta{"Machine", t.Name})
+		return
+	}
+
+	log.Printf("Saving diagram %s...", file)
+	w, err := os.Create(file)
+	if err != nil {
+		log.Panicf("Couldn't create %s: %s", file, err)
+	}
+	defer w.Close()
+	if format == "dot" {
+		g.WriteDOT(w)
+	} else {
+		g.WriteSVG(w)
+	}
+}
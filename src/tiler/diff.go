@@ -0,0 +1,153 @@
+package tiler
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+)
+
+// pixels across each cell of a diff image
+const diffCellSize = 6
+
+// every configuration of a simulated run, in order, and whether it ended
+// before MaxDepth
+func (t *Tiler) simulateAll(input string) ([]Config, bool) {
+	var confs []Config
+	completed := t.simulateRows(input, func(conf *Config) {
+		c := *conf
+		c.Tape = append([]rune(nil), conf.Tape...)
+		confs = append(confs, c)
+	})
+	return confs, completed
+}
+
+// whether two configurations draw cell i differently
+func cellDiffers(a, b *Config, i int) bool {
+	inA, inB := i < len(a.Tape), i < len(b.Tape)
+	if inA != inB {
+		return true
+	}
+	if !inA {
+		return false
+	}
+	if a.Tape[i] != b.Tape[i] || (a.Head == i) != (b.Head == i) {
+		return true
+	}
+	return a.Head == i && (a.State != b.State || a.Halted != b.Halted || a.Output != b.Output)
+}
+
+// Diff simulates this machine on one input and another machine (possibly the
+// same one) on another, step by step. it saves the two space-time diagrams side
+// by side with the cells that differ framed in red, and describes the first
+// divergence to w.
+func (t *Tiler) Diff(input string, other *Tiler, otherInput string, w io.Writer) {
+	log.Printf("Simulating %s on %q...", t.Name, input)
+	a, completedA := t.simulateAll(input)
+	log.Printf("Simulating %s on %q...", other.Name, otherInput)
+	b, completedB := other.simulateAll(otherInput)
+
+	nameA := fmt.Sprintf("%s %q", t.Name, input)
+	nameB := fmt.Sprintf("%s %q", other.Name, otherInput)
+	width := len(a[0].Tape)
+	if len(b[0].Tape) > width {
+		width = len(b[0].Tape)
+	}
+	steps := len(a)
+	if len(b) > steps {
+		steps = len(b)
+	}
+
+	// both panels are colored by this tiler so that equal cells look equal
+	panelW := width*diffCellSize + diffCellSize
+	im := image.NewRGBA(image.Rect(0, 0, 2*panelW, steps*diffCellSize))
+	draw.Draw(im, im.Bounds(), image.White, image.ZP, draw.Src)
+	red := image.NewUniform(color.RGBA{255, 0, 0, 255})
+	empty := &Config{Head: -1}
+
+	firstStep, firstCell := -1, -1
+	for step := 0; step < steps; step++ {
+		ca, cb := empty, empty
+		if step < len(a) {
+			ca = &a[step]
+		}
+		if step < len(b) {
+			cb = &b[step]
+		}
+		for i := 0; i < width; i++ {
+			y := step * diffCellSize
+			for panel, conf := range []*Config{ca, cb} {
+				if i >= len(conf.Tape) {
+					continue
+				}
+				x := panel*panelW + i*diffCellSize
+				draw.Draw(im, image.Rect(x, y, x+diffCellSize, y+diffCellSize),
+					image.NewUniform(t.cellColor(conf, i)), image.ZP, draw.Src)
+			}
+			if !cellDiffers(ca, cb, i) {
+				continue
+			}
+			if firstStep < 0 {
+				firstStep, firstCell = step, i
+			}
+			for panel := 0; panel < 2; panel++ {
+				x := panel*panelW + i*diffCellSize
+				r := image.Rect(x, y, x+diffCellSize, y+diffCellSize)
+				for _, edge := range []image.Rectangle{
+					{r.Min, image.Pt(r.Max.X, r.Min.Y+1)},
+					{image.Pt(r.Min.X, r.Max.Y-1), r.Max},
+					{r.Min, image.Pt(r.Min.X+1, r.Max.Y)},
+					{image.Pt(r.Max.X-1, r.Min.Y), r.Max},
+				} {
+					draw.Draw(im, edge, red, image.ZP, draw.Src)
+				}
+			}
+		}
+	}
+
+	describe := func(name string, confs []Config, completed bool) string {
+		last := confs[len(confs)-1]
+		switch {
+		case !completed:
+			return fmt.Sprintf("%s hit max depth in state %s after %d steps", name, last.State, len(confs)-1)
+		case last.Halted && last.Output != "":
+			return fmt.Sprintf("%s halted with output %s after %d steps", name, last.Output, len(confs)-1)
+		case last.Halted:
+			return fmt.Sprintf("%s halted after %d steps", name, len(confs)-1)
+		}
+		return fmt.Sprintf("%s stopped in state %s after %d steps", name, last.State, len(confs)-1)
+	}
+	fmt.Fprintln(w, describe(nameA, a, completedA))
+	fmt.Fprintln(w, describe(nameB, b, completedB))
+	if firstStep < 0 {
+		fmt.Fprintln(w, "no differences")
+	} else {
+		fmt.Fprintf(w, "first difference at step %d, tape position %d\n", firstStep, firstCell)
+		for _, side := range []struct {
+			name      string
+			confs     []Config
+			completed bool
+		}{{nameA, a, completedA}, {nameB, b, completedB}} {
+			if firstStep >= len(side.confs) {
+				ended := "stopped"
+				if !side.completed {
+					ended = "hit max depth"
+				}
+				fmt.Fprintf(w, "  %s: already %s\n", side.name, ended)
+				continue
+			}
+			conf := side.confs[firstStep]
+			symbol := ""
+			if firstCell < len(conf.Tape) {
+				symbol = string(conf.Tape[firstCell])
+			}
+			fmt.Fprintf(w, "  %s: state %s, head at %d, symbol %q\n", side.name, conf.State, conf.Head, symbol)
+		}
+	}
+
+	t.saveImage(t.outputFile(fmt.Sprintf("%s-vs-%s-%s", input, other.Name, otherInput), "-diff", t.imageFormat()), im,
+		imageMeta{"Software", "turing-tiler"}, imageMeta{"Machine", t.Name}, imageMeta{"Input", input},
+		imageMeta{"Other Machine", other.Name}, imageMeta{"Other Input", otherInput})
+}
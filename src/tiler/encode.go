@@ -0,0 +1,291 @@
+package tiler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// a writer which fails with ctx's error once ctx is done, so that encoding an
+// image stops when it's no longer wanted
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// a piece of text stored in an image to trace it back to the run that made it
+type imageMeta struct {
+	Key, Value string
+}
+
+// an imageEncoder writes an image and its metadata in one format, giving up
+// with ctx's error if ctx is done first
+type imageEncoder func(ctx context.Context, w io.Writer, im image.Image, meta []imageMeta, o *Options) error
+
+// encoders by file extension
+var imageEncoders = map[string]imageEncoder{
+	"png":  encodePNG,
+	"jpeg": encodeJPEG,
+	"jpg":  encodeJPEG,
+	"gif":  encodeGIF,
+	"bmp":  encodeBMP,
+	"tiff": encodeTIFF,
+	"tif":  encodeTIFF,
+}
+
+// the extension images are written with
+func (t *Tiler) imageFormat() string {
+	if t.Format == "" {
+		return "png"
+	}
+	return t.Format
+}
+
+// saveImage writes an image in the format given by the file's extension
+func (t *Tiler) saveImage(file string, im image.Image, meta ...imageMeta) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(file), "."))
+	encode, exists := imageEncoders[ext]
+	if !exists {
+		log.Panicf("Unknown image format %q, expected png, jpeg, gif, bmp or tiff", ext)
+	}
+	log.Printf("Saving image %s...", file)
+	w, err := os.Create(file)
+	if err != nil {
+		log.Panicf("Couldn't create %s: %s", file, err)
+	}
+	defer w.Close()
+	if err := encode(context.Background(), w, im, meta, &t.Options); err != nil {
+		log.Panicf("Couldn't encode %s: %s", file, err)
+	}
+}
+
+// the metadata of an assembly or simulation: what ran on what, and how it ended
+func (t *Tiler) runMeta(input string, steps int, last *Config) []imageMeta {
+	meta := []imageMeta{
+		{"Software", "turing-tiler"},
+		{"Machine", t.Name},
+		{"Input", input},
+		{"Steps", strconv.Itoa(steps)},
+	}
+	if last.Halted {
+		meta = append(meta, imageMeta{"Output", last.Output})
+	}
+	return meta
+}
+
+// metadata as lines of "key: value", for formats with a single comment
+func metaText(meta []imageMeta) string {
+	var lines []string
+	for _, m := range meta {
+		lines = append(lines, m.Key+": "+m.Value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// png gets an iTXt chunk, which holds utf-8, per item straight after IHDR
+func encodePNG(ctx context.Context, w io.Writer, im image.Image, meta []imageMeta, o *Options) error {
+	var buf bytes.Buffer
+	if err := png.Encode(contextWriter{ctx, &buf}, im); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	ihdrEnd := len(pngSignature) + 8 + 13 + 4
+	if _, err := w.Write(data[:ihdrEnd]); err != nil {
+		return err
+	}
+	for _, m := range meta {
+		if _, err := w.Write(pngChunk("iTXt", iTXt(m))); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(data[ihdrEnd:])
+	return err
+}
+
+// the keyword, then no compression, no language and no translated keyword
+func iTXt(m imageMeta) []byte {
+	return []byte(m.Key + "\x00\x00\x00\x00\x00" + m.Value)
+}
+
+func pngChunk(kind string, data []byte) []byte {
+	chunk := make([]byte, 8, len(data)+12)
+	binary.BigEndian.PutUint32(chunk[0:], uint32(len(data)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, data...)
+	crc := crc32.ChecksumIEEE(chunk[4:])
+	return append(chunk, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+// jpeg gets a comment segment straight after the start of image marker
+func encodeJPEG(ctx context.Context, w io.Writer, im image.Image, meta []imageMeta, o *Options) error {
+	var buf bytes.Buffer
+	quality := o.Quality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	if err := jpeg.Encode(contextWriter{ctx, &buf}, im, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	text := metaText(meta)
+	if len(text) > 0xffff-2 {
+		text = text[:0xffff-2]
+	}
+	segment := []byte{0xff, 0xfe, byte((len(text) + 2) >> 8), byte(len(text) + 2)}
+	segment = append(segment, text...)
+	if _, err := w.Write(data[:2]); err != nil {
+		return err
+	}
+	if _, err := w.Write(segment); err != nil {
+		return err
+	}
+	_, err := w.Write(data[2:])
+	return err
+}
+
+// gif is quantized to the most common colors of the image, dithering the rest,
+// and gets a comment extension ahead of the image data
+func encodeGIF(ctx context.Context, w io.Writer, im image.Image, meta []imageMeta, o *Options) error {
+	b := im.Bounds()
+	counts := make(map[color.RGBA]int)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			counts[color.RGBAModel.Convert(im.At(x, y)).(color.RGBA)]++
+		}
+	}
+	var colors []color.RGBA
+	for c := range counts {
+		colors = append(colors, c)
+	}
+	sort.Slice(colors, func(i, j int) bool {
+		if counts[colors[i]] != counts[colors[j]] {
+			return counts[colors[i]] > counts[colors[j]]
+		}
+		key := func(c color.RGBA) uint32 { return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A) }
+		return key(colors[i]) < key(colors[j])
+	})
+	var palette color.Palette
+	for _, c := range colors {
+		if len(palette) == 256 {
+			break
+		}
+		palette = append(palette, c)
+	}
+	paletted := image.NewPaletted(b, palette)
+	if len(colors) > 256 {
+		draw.FloydSteinberg.Draw(paletted, b, im, b.Min)
+	} else {
+		draw.Draw(paletted, b, im, b.Min, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.Encode(contextWriter{ctx, &buf}, paletted, &gif.Options{NumColors: len(palette)}); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	// the header and logical screen descriptor, then any global color table
+	start := 13
+	if data[10]&0x80 != 0 {
+		start += 3 << (uint(data[10]&7) + 1)
+	}
+	ext := []byte{0x21, 0xfe}
+	text := []byte(metaText(meta))
+	for len(text) > 0 {
+		n := len(text)
+		if n > 255 {
+			n = 255
+		}
+		ext = append(ext, byte(n))
+		ext = append(ext, text[:n]...)
+		text = text[n:]
+	}
+	ext = append(ext, 0)
+	if _, err := w.Write(data[:start]); err != nil {
+		return err
+	}
+	if _, err := w.Write(ext); err != nil {
+		return err
+	}
+	_, err := w.Write(data[start:])
+	return err
+}
+
+// bmp has nowhere to keep metadata, so it's dropped
+func encodeBMP(ctx context.Context, w io.Writer, im image.Image, meta []imageMeta, o *Options) error {
+	if len(meta) > 0 {
+		log.Printf("  Warning: bmp images can't hold metadata; use another -format to keep it")
+	}
+	return bmp.Encode(contextWriter{ctx, w}, im)
+}
+
+// tiff gets an ImageDescription tag. the encoder has no way to add tags, so
+// the description and a copy of its directory with the tag added are appended
+// and the header pointed at the new directory.
+func encodeTIFF(ctx context.Context, w io.Writer, im image.Image, meta []imageMeta, o *Options) error {
+	var buf bytes.Buffer
+	if err := tiff.Encode(contextWriter{ctx, &buf}, im, &tiff.Options{Compression: tiff.Deflate}); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	le := binary.LittleEndian // the encoder always writes "II"
+	ifd := le.Uint32(data[4:])
+	n := int(le.Uint16(data[ifd:]))
+	entries := make([][]byte, 0, n+1)
+	for i := 0; i < n; i++ {
+		entries = append(entries, data[int(ifd)+2+12*i:int(ifd)+2+12*(i+1)])
+	}
+
+	text := append([]byte(metaText(meta)), 0)
+	textOffset := len(data)
+	data = append(data, text...)
+	if len(data)%2 == 1 {
+		data = append(data, 0) // directories start on a word boundary
+	}
+	const imageDescription, ascii = 270, 2
+	entry := make([]byte, 12)
+	le.PutUint16(entry[0:], imageDescription)
+	le.PutUint16(entry[2:], ascii)
+	le.PutUint32(entry[4:], uint32(len(text)))
+	le.PutUint32(entry[8:], uint32(textOffset))
+	entries = append(entries, entry)
+	sort.SliceStable(entries, func(i, j int) bool { return le.Uint16(entries[i]) < le.Uint16(entries[j]) })
+
+	newIFD := len(data)
+	var count [2]byte
+	le.PutUint16(count[:], uint16(len(entries)))
+	data = append(data, count[:]...)
+	for _, e := range entries {
+		data = append(data, e...)
+	}
+	data = append(data, 0, 0, 0, 0) // no next directory
+	le.PutUint32(data[4:], uint32(newIFD))
+	_, err := w.Write(data)
+	return err
+}
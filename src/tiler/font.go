@@ -0,0 +1,111 @@
+package tiler
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"log"
+
+	"code.google.com/p/freetype-go/freetype"
+	"code.google.com/p/freetype-go/freetype/truetype"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// the height of capitals and digits as a fraction of the font size, near
+// enough for centering labels vertically in most fonts
+const capHeight = 0.7
+
+// load the chain of fonts to draw with: FontPath, then each of FallbackFonts,
+// then the bundled Go font so that rendering never depends on what's installed
+func (t *Tiler) loadFonts() {
+	var paths []string
+	if t.FontPath != "" {
+		paths = append(paths, t.FontPath)
+	}
+	paths = append(paths, t.FallbackFonts...)
+
+	t.fonts = nil
+	for _, path := range paths {
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Panicf("Couldn't read font: %s", err)
+		}
+		font, err := freetype.ParseFont(bytes)
+		if err != nil {
+			log.Panicf("Couldn't parse font %s: %s", path, err)
+		}
+		t.fonts = append(t.fonts, font)
+	}
+	font, err := freetype.ParseFont(goregular.TTF)
+	if err != nil {
+		log.Panicf("Couldn't parse bundled font: %s", err)
+	}
+	t.fonts = append(t.fonts, font)
+}
+
+// the first font in the chain with a glyph for r, or the first font if none
+// has one so that it's drawn as that font's missing glyph
+func (t *Tiler) fontFor(r rune) *truetype.Font {
+	for _, font := range t.fonts {
+		if font.Index(r) != 0 {
+			return font
+		}
+	}
+	return t.fonts[0]
+}
+
+// a stretch of a string drawn with a single font
+type textRun struct {
+	font *truetype.Font
+	text string
+}
+
+// split a string into runs by the font each character comes from
+func (t *Tiler) textRuns(s string) []textRun {
+	var runs []textRun
+	for _, r := range s {
+		font := t.fontFor(r)
+		if n := len(runs); n > 0 && runs[n-1].font == font {
+			runs[n-1].text += string(r)
+		} else {
+			runs = append(runs, textRun{font, string(r)})
+		}
+	}
+	return runs
+}
+
+// textWidth measures a string in pixels at the given size from the advance
+// widths and kerning of the fonts it'll be drawn with
+func (t *Tiler) textWidth(s string, size float64) float64 {
+	var width float64
+	for _, run := range t.textRuns(s) {
+		fupe := run.font.FUnitsPerEm()
+		var units int32
+		var prev truetype.Index
+		for i, r := range []rune(run.text) {
+			index := run.font.Index(r)
+			if i > 0 {
+				units += run.font.Kerning(fupe, prev, index)
+			}
+			units += run.font.HMetric(fupe, index).AdvanceWidth
+			prev = index
+		}
+		width += float64(units) * size / float64(fupe)
+	}
+	return width
+}
+
+// drawText draws a string with the left end of its baseline at x, y,
+// switching fonts wherever the current one lacks a glyph
+func (t *Tiler) drawText(im *image.RGBA, col color.RGBA, s string, size float64, x, y int) {
+	pt := freetype.Pt(x, y)
+	for _, run := range t.textRuns(s) {
+		c := t.newTypeContext(im, col)
+		c.SetFont(run.font)
+		c.SetFontSize(size)
+		var err error
+		if pt, err = c.DrawString(run.text, pt); err != nil {
+			log.Panicf("Couldn't draw %q: %s", s, err)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package tiler
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// the fraction of an image's pixels which may differ from its golden by
+// default
+const GoldenTolerance = 0.002
+
+// colors further apart than this in CIELAB are told apart at a glance; closer
+// ones are the kind of difference antialiasing or rounding makes
+const goldenDeltaE = 8
+
+// labels were drawn by gd, which hints glyphs, so they're compared by how
+// much of each pixel is covered in their ink rather than by color: a pixel
+// must be a blend of the ink and what's under it no further than this from
+// one, and one mostly covered must have one at least partly covered within
+// goldenReach in the other image. the rest are antialiased edges.
+const (
+	goldenLabelDeltaE   = 16
+	goldenReach         = 2
+	goldenCovered       = 0.5
+	goldenPartlyCovered = 0.25
+)
+
+// the options every golden image is drawn with, so that they don't depend on
+// the flags of whoever runs the check: those assemble.pl drew the gallery
+// with, time running down the image
+func (o *Options) goldenOptions(machineFile string) Options {
+	return Options{
+		TileWidth:          32,
+		TileHeight:         24,
+		FontSize:           9.24,
+		ClassicLabels:      true,
+		MaxDepth:           10000,
+		IgnoreDepthFailure: true,
+		FlipVertical:       true,
+		BoundarySymbol:     '*',
+		MachineFile:        machineFile,
+		OutputDir:          o.OutputDir,
+	}
+}
+
+// CheckGoldens redraws every case of every example with pinned options and
+// compares it with the image of the same name beside the machine. images
+// differ if more than tolerance of their pixels are perceptibly different, in
+// which case a diff image is written as the run command would write the image.
+// with update set, missing or different goldens are replaced instead. a line
+// per case is written to w, and the number of failures returned.
+func (o *Options) CheckGoldens(examples []Example, tolerance float64, update bool, w io.Writer) int {
+	failures, cases := 0, 0
+	for i := range examples {
+		e := &examples[i]
+		if len(e.Cases) == 0 {
+			continue
+		}
+		options := o.goldenOptions(e.MachineFile)
+		name := options.NewMachineTiler().Name
+		for _, c := range e.Cases {
+			cases++
+			file := fmt.Sprintf("%s-%s", name, c.Input)
+			golden := filepath.Join(filepath.Dir(e.MachineFile), file+".png")
+			diffFile := filepath.Join(o.exampleDir(e), file+"-golden-diff.png")
+			var failure string
+			if p := catchPanic(func() { failure = options.checkGolden(c, golden, diffFile, tolerance, update) }); p != "" {
+				failure = p
+			}
+			if failure != "" {
+				fmt.Fprintf(w, "FAIL  %s: %s\n", golden, failure)
+				failures++
+			} else {
+				fmt.Fprintf(w, "ok    %s\n", golden)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d images, %d failed\n", cases, failures)
+	return failures
+}
+
+// checkGolden compares a case's image with a golden, returning how they
+// differ or "" if they match
+func (o *Options) checkGolden(c Case, golden, diffFile string, tolerance float64, update bool) string {
+	options := *o
+	if c.Theme != "" {
+		options.Theme = filepath.Join(filepath.Dir(o.MachineFile), c.Theme)
+	}
+	options.FlipHorizontal = c.FlipHorizontal
+	if c.Tolerance > 0 {
+		tolerance = c.Tolerance
+	}
+	t := options.NewTiler()
+	im, outcome := t.RenderOne(c.Input)
+	if im == nil {
+		return outcome.Failure
+	}
+
+	var want image.Image
+	if f, err := os.Open(golden); err == nil {
+		want, err = png.Decode(f)
+		f.Close()
+		if err != nil {
+			return fmt.Sprintf("couldn't decode the golden image: %s", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err.Error()
+	}
+
+	failure := ""
+	switch {
+	case want == nil:
+		failure = "no golden image; run with -update to create it"
+	case want.Bounds() != im.Bounds():
+		failure = fmt.Sprintf("image is %dx%d, golden is %dx%d", im.Bounds().Dx(), im.Bounds().Dy(),
+			want.Bounds().Dx(), want.Bounds().Dy())
+	default:
+		// drawn again without labels and with boxes in their place, to find
+		// where they are and what color they're in
+		redraw := func(style labelStyle) image.Image {
+			bare := options.NewTiler()
+			bare.labelStyle = style
+			for i := range bare.tiles {
+				bare.tiles[i].Image = bare.generateImage(&bare.tiles[i])
+			}
+			im, _ := bare.RenderOne(c.Input)
+			return im
+		}
+
+		differing, diff := imageDifference(want, im, redraw(labelHidden), redraw(labelBoxes), c.IgnoreLabels)
+		if differing == 0 {
+			os.Remove(diffFile)
+			return ""
+		}
+		fraction := float64(differing) / float64(im.Bounds().Dx()*im.Bounds().Dy())
+		if fraction <= tolerance && !update {
+			os.Remove(diffFile)
+			return ""
+		}
+		failure = fmt.Sprintf("%d pixels (%.3f%%) differ; see %s", differing, 100*fraction, diffFile)
+		if !update {
+			if err := os.MkdirAll(filepath.Dir(diffFile), 0755); err != nil {
+				return err.Error()
+			}
+			t.saveImage(diffFile, diff)
+		}
+	}
+
+	if update {
+		t.saveImage(golden, im, t.runMeta(c.Input, outcome.Steps, &Config{Halted: outcome.Halted, Output: outcome.Output})...)
+		os.Remove(diffFile)
+		return ""
+	}
+	return failure
+}
+
+// imageDifference counts the pixels of two images of the same size which are
+// perceptibly different, and draws the first faded to gray with those pixels
+// in red. unlabelled and boxed are b drawn without labels and with solid boxes
+// in their place; in and near those boxes, pixels are compared by how much
+// they're covered by the label's ink, or not at all with ignoreLabels.
+func imageDifference(a, b, unlabelled, boxed image.Image, ignoreLabels bool) (int, *image.RGBA) {
+	r := a.Bounds()
+	la, lb, lu, lbox := labImage(a), labImage(b), labImage(unlabelled), labImage(boxed)
+
+	// the inks of the labels each pixel is in or near, as 1 more than their
+	// place in inks. two are enough where labels meet.
+	var inks [][3]float64
+	inkIndex := make(map[[3]float64]uint16)
+	near := make([][2]uint16, len(la))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := (y-r.Min.Y)*r.Dx() + x - r.Min.X
+			if lbox[i] == lu[i] {
+				continue
+			}
+			n, exists := inkIndex[lbox[i]]
+			if !exists {
+				inks = append(inks, lbox[i])
+				n = uint16(len(inks))
+				inkIndex[lbox[i]] = n
+			}
+			forNear(r, x, y, func(j int) bool {
+				if near[j][0] == 0 || near[j][0] == n {
+					near[j][0] = n
+				} else if near[j][1] == 0 {
+					near[j][1] = n
+				}
+				return false
+			})
+		}
+	}
+	// how much each pixel is covered by the ink of any label it's near
+	coverage := func(l [][3]float64, i int) float64 {
+		c := -1.0
+		for _, n := range near[i] {
+			if n != 0 {
+				c = math.Max(c, inkCoverage(l[i], lu[i], inks[n-1]))
+			}
+		}
+		return c
+	}
+	coverA, coverB := make([]float64, len(la)), make([]float64, len(la))
+	for i := range near {
+		if near[i][0] != 0 {
+			coverA[i], coverB[i] = coverage(la, i), coverage(lb, i)
+		}
+	}
+
+	diff := image.NewRGBA(r)
+	red := color.RGBA{255, 0, 0, 255}
+	differing := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := (y-r.Min.Y)*r.Dx() + x - r.Min.X
+			if deltaE(la[i], lb[i]) > goldenDeltaE && (near[i][0] == 0 || !ignoreLabels &&
+				(!coveredNear(coverA[i], coverB, r, x, y) || !coveredNear(coverB[i], coverA, r, x, y))) {
+				diff.SetRGBA(x, y, red)
+				differing++
+				continue
+			}
+			gray := color.GrayModel.Convert(a.At(x, y)).(color.Gray).Y
+			gray = 255 - (255-gray)/4
+			diff.SetRGBA(x, y, color.RGBA{gray, gray, gray, 255})
+		}
+	}
+	return differing, diff
+}
+
+// inkCoverage says how much of a pixel of color c is covered by ink over
+// under, from 0 to 1, or -1 if c isn't a blend of the two
+func inkCoverage(c, under, ink [3]float64) float64 {
+	var along, length float64
+	for k := range c {
+		along += (c[k] - under[k]) * (ink[k] - under[k])
+		length += (ink[k] - under[k]) * (ink[k] - under[k])
+	}
+	f := 0.0
+	if length > 0 {
+		f = math.Max(0, math.Min(1, along/length))
+	}
+	var blend [3]float64
+	for k := range c {
+		blend[k] = under[k] + f*(ink[k]-under[k])
+	}
+	if deltaE(c, blend) > goldenLabelDeltaE {
+		return -1
+	}
+	return f
+}
+
+// coveredNear says whether a pixel of one image with the given coverage
+// matches the other, whose coverage is cover: it must be a blend of ink, and
+// if mostly covered have a pixel at least partly covered within goldenReach
+// of x, y in the other
+func coveredNear(c float64, cover []float64, r image.Rectangle, x, y int) bool {
+	if c < goldenCovered {
+		return c >= 0
+	}
+	return forNear(r, x, y, func(j int) bool { return cover[j] >= goldenPartlyCovered })
+}
+
+// forNear calls f with the index of each pixel of r within goldenReach of x, y
+// until it returns true, returning whether it did
+func forNear(r image.Rectangle, x, y int, f func(j int) bool) bool {
+	for ny := max(y-goldenReach, r.Min.Y); ny <= min(y+goldenReach, r.Max.Y-1); ny++ {
+		for nx := max(x-goldenReach, r.Min.X); nx <= min(x+goldenReach, r.Max.X-1); nx++ {
+			if f((ny-r.Min.Y)*r.Dx() + nx - r.Min.X) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// labImage converts every pixel of an image to CIELAB, row by row
+func labImage(im image.Image) [][3]float64 {
+	r := im.Bounds()
+	out := make([][3]float64, 0, r.Dx()*r.Dy())
+	seen := make(map[color.RGBA][3]float64)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.RGBAModel.Convert(im.At(x, y)).(color.RGBA)
+			l, ok := seen[c]
+			if !ok {
+				l[0], l[1], l[2] = lab(c)
+				seen[c] = l
+			}
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// deltaE is the CIE76 distance between two colors in CIELAB: euclidean, as
+// equal distances there look about equally different
+func deltaE(a, b [3]float64) float64 {
+	return math.Sqrt((a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2]))
+}
+
+// convert sRGB to CIELAB under a D65 white point
+func lab(c color.RGBA) (l, a, b float64) {
+	linear := func(v uint8) float64 {
+		f := float64(v) / 255
+		if f <= 0.04045 {
+			return f / 12.92
+		}
+		return math.Pow((f+0.055)/1.055, 2.4)
+	}
+	r, g, bl := linear(c.R), linear(c.G), linear(c.B)
+	x := (0.4124*r + 0.3576*g + 0.1805*bl) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*bl
+	z := (0.0193*r + 0.1192*g + 0.9505*bl) / 1.08883
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
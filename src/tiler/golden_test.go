@@ -0,0 +1,32 @@
+package tiler
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "replace the golden images which differ with the new drawings")
+
+// the examples are checked as "turing-tiler golden" checks them
+func TestGoldens(t *testing.T) {
+	if testing.Short() {
+		t.Skip("drawing every example takes a while")
+	}
+	machines, err := filepath.Glob(filepath.Join("..", "..", "*", "*.machine"))
+	if err != nil || len(machines) == 0 {
+		t.Fatalf("no example machines found: %v", err)
+	}
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	var options Options
+	var out bytes.Buffer
+	if failures := options.CheckGoldens(FindExamples(machines), GoldenTolerance, *update, &out); failures > 0 {
+		t.Errorf("%d golden images differ:\n%s", failures, out.String())
+	}
+}
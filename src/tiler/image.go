@@ -2,18 +2,18 @@ package tiler
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/binary"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"image/png"
-	"io/ioutil"
 	"log"
 	. "math"
 	"os"
 	"regexp"
+	"strings"
 
 	"code.google.com/p/freetype-go/freetype"
 	"code.google.com/p/freetype-go/freetype/truetype"
@@ -27,50 +27,50 @@ type Cell struct {
 type drawer struct {
 	// drawing constants computed from Options
 	fontSize,
-	bondFudgeX, bondFudgeY,
+	bondFudgeX, bondFudgeY int
 	tileHorizShift, tileVertShift,
-	tileHorizMargin, tileVertMargin,
-	fontWidth, fontHeight,
-	fontX, fontY int
+	tileHorizMargin, tileVertMargin float64
 
-	font   *truetype.Font
-	colors map[string]color.RGBA
+	fonts   []*truetype.Font // tried in order for each glyph
+	colors  map[string]color.RGBA
+	palette palette
+
+	labelStyle labelStyle
 }
 
-func (t *Tiler) setupDrawer() {
-	t.bondFudgeX = int(Floor(float64(t.TileWidth) / 80))
-	t.bondFudgeY = int(Floor(float64(t.TileHeight) / 80))
-	t.fontSize = int(Sqrt(float64(t.TileHeight*t.TileWidth)) / 4)
-	t.tileHorizShift = int(float64(t.TileWidth) / 10)
-	t.tileVertShift = int(float64(t.TileHeight) / 10)
-	t.tileHorizMargin = int(float64(t.TileWidth) / 20)
-	t.tileVertMargin = int(float64(t.TileHeight) / 20)
+// how tile labels are drawn. the golden check draws tiles again without them
+// and with solid boxes in their place, to find where they are and their ink.
+type labelStyle int
 
-	bytes, err := ioutil.ReadFile(t.FontPath)
-	if err != nil {
-		log.Panicf("Couldn't read font: %s", err)
-	}
-	t.font, err = freetype.ParseFont(bytes)
-	if err != nil {
-		log.Panicf("Couldn't parse font: %s", err)
-	}
+const (
+	labelText labelStyle = iota
+	labelHidden
+	labelBoxes
+)
+
+func (t *Tiler) setupDrawer() {
+	t.setupMetrics()
+	t.loadFonts()
+}
 
-	// figure out how big a representative character is for approximate layout purposes
-	ex := '5'
-	fupe := t.font.FUnitsPerEm()
-	horiz := t.font.HMetric(fupe, t.font.Index(ex))
-	vert := t.font.VMetric(fupe, t.font.Index(ex))
-	t.fontX = int(horiz.LeftSideBearing)
-	t.fontWidth = int(horiz.AdvanceWidth)
-	t.fontY = int(vert.TopSideBearing)
-	t.fontHeight = int(vert.AdvanceHeight)
-	log.Printf("%#v", t)
+// drawing constants which follow from the tile size. as in assemble.pl,
+// shifts and margins keep their fractions and only the coordinates worked out
+// from them are truncated, and the fudge is rounded half to even as sprintf
+// does.
+func (t *Tiler) setupMetrics() {
+	t.bondFudgeX = int(RoundToEven(float64(t.TileWidth) / 80))
+	t.bondFudgeY = int(RoundToEven(float64(t.TileHeight) / 80))
+	t.fontSize = int(Sqrt(float64(t.TileHeight*t.TileWidth)) / 4)
+	t.tileHorizShift = float64(t.TileWidth) / 10
+	t.tileVertShift = float64(t.TileHeight) / 10
+	t.tileHorizMargin = float64(t.TileWidth) / 20
+	t.tileVertMargin = float64(t.TileHeight) / 20
 }
 
 func (t *Tiler) newTypeContext(im *image.RGBA, color color.RGBA) *freetype.Context {
 	c := freetype.NewContext()
 	c.SetDPI(72)
-	c.SetFont(t.font)
+	c.SetFont(t.fonts[0])
 	c.SetFontSize(t.FontSize)
 	c.SetClip(im.Bounds())
 	c.SetDst(im)
@@ -86,50 +86,59 @@ func (t *Tiler) Assemble() {
 
 type Assembly [][]*Tile
 
-func (t *Tiler) AssembleOne(input string) {
+// Outcome is how the run on an input ended. Failure is empty if the machine
+// halted, and otherwise says why it didn't.
+type Outcome struct {
+	Input   string
+	Steps   int
+	Halted  bool
+	Output  string
+	Failure string
+}
+
+func (t *Tiler) outcome(input string, steps int, last *Config, completed bool) Outcome {
+	o := Outcome{Input: input, Steps: steps, Halted: last.Halted, Output: last.Output}
+	switch {
+	case !completed:
+		o.Failure = fmt.Sprintf("hit maximum depth (%d)", t.MaxDepth)
+	case !last.Halted && last.State == "":
+		o.Failure = "stalled"
+	case !last.Halted:
+		o.Failure = fmt.Sprintf("stalled in state %s", last.State)
+	}
+	return o
+}
+
+func (t *Tiler) AssembleOne(input string) Outcome {
 	log.Printf("Processing input %q...", input)
 
 	// check that the input string has only legal symbols
-	symbolRx := regexp.MustCompile(fmt.Sprintf("[^%s]", string(t.Symbols)))
-	if symbolRx.MatchString(input) {
+	if !t.validInput(input) {
 		log.Printf("  Warning: invalid symbol encountered in input string %q", input)
-		return
+		return Outcome{Input: input, Failure: "invalid symbol in input"}
 	}
 
-	// annotate initial input with head semantics before generating starter tiles
-	cells := make([]Cell, len(input))
-	for i, r := range input {
-		cells[i] = Cell{r, i == t.InitialLocation}
+	if t.Compact == "simulator" && (t.CheckpointInterval > 0 || t.Resume) {
+		log.Printf("  Warning: compact images of the simulator can't be checkpointed or resumed")
 	}
-
-	log.Printf("Generating starter tiles...")
-
-	// seed first row of assembly with starter tiles from input-generated cells
-	assembly := Assembly{make([]*Tile, len(cells)+2)}
-
-	// wrap with boundary symbols at beginning and end
-	assembly[0][0] = t.cellToTile(&Cell{t.BoundarySymbol, false}, true, false)
-	for i, cell := range cells {
-		assembly[0][i+1] = t.cellToTile(&cell, false, false)
+	if t.Compact != "" {
+		return t.compactOne(input)
 	}
-	assembly[0][len(assembly)-1] = t.cellToTile(&Cell{t.BoundarySymbol, false}, false, true)
-
-	log.Printf("Assembling transition tiles...")
-
-	// assemble until we hit a halting state or the depth limit is reached
-	for t.MaxDepth == 0 || len(assembly) < t.MaxDepth {
-		t.addTile(assembly)
+	if t.Stream {
+		return t.streamOne(input)
 	}
 
-	if len(assembly) > t.MaxDepth {
-		assembly = assembly[:t.MaxDepth]
-		log.Printf("  Warning: assembly hit maximum depth (%d), increase with -max-depth", t.MaxDepth)
+	var assembly Assembly
+	completed := t.assembleCheckpointed(input, func(row []*Tile) { assembly = append(assembly, row) })
+	last := tileRowConfig(assembly[len(assembly)-1])
+	outcome := t.outcome(input, len(assembly)-1, last, completed)
+	if !completed && !t.IgnoreDepthFailure {
+		return outcome
 	}
 
-	// remove trailing blank line
-	assembly = assembly[:len(assembly)-2]
-
-	sizeX, sizeY := len(assembly[0]), len(assembly)
+	if t.Report {
+		t.writeReport(input, assembly)
+	}
 
 	log.Printf("Transforming matrix...")
 
@@ -137,117 +146,228 @@ func (t *Tiler) AssembleOne(input string) {
 	// itself only with a single logical layout (bottom-up). here we rotate the
 	// assembly matrix to the desired final orientation; tile orientation is
 	// also rotated, but in the drawing routines
-	sizeX, sizeY, assembly = t.computeRotated(sizeX, sizeY, assembly)
-
-	/*
-		// create the master canvas containing the record of the entire computation
-		my $target = GD::Image->new( t.TileWidth * $size_x - $size_x + 1, t.TileHeight * $size_y - $size_y + 1 );
-		$target->saveAlpha( 1 );
-
-		print STDERR "  Generating canvas...\n";
-
-		// copy component tiles to the master canvas
-		for my $i ( 0 .. @assembly - 1 ) {
-			for my $j ( 0 .. @{ $assembly[ $i ] } - 1 ) {
-				my $src = $assembly[ $i ][ $j ]{ image };
-				next unless defined $src; // silently ignored missing tiles
-				$target->copy( $src,
-					t.TileWidth * $j - $j,
-					t.TileHeight * ( @assembly - $i - 1 ) - ( @assembly - $i - 1 ),
-					0, 0, t.TileWidth, t.TileHeight
-				);
-			}
-		}
+	sizeX, sizeY, rotated := t.computeRotated(len(assembly[0]), len(assembly), assembly)
 
-		// save the output
+	if t.TextOutput {
+		t.WriteText(os.Stdout, rotated, isTerminal(os.Stdout))
+	}
 
-		my $output_file = "$name-$input_string.png";
-		print STDERR "  Saving image $output_file...\n";
-		open OUTPUT, ">", $output_file;
-		binmode OUTPUT;
-		print OUTPUT $target->png;
-		close OUTPUT;
+	if t.Pyramid {
+		file := t.outputFile(input, "", "dzi")
+		t.writePyramid(strings.TrimSuffix(file, ".dzi"), sizeX, sizeY, rotated)
+	} else {
+		im, _ := t.drawAssembly(context.Background(), input, assembly, rotated)
+		t.saveImage(t.outputFile(input, "", t.imageFormat()), im, t.runMeta(input, outcome.Steps, last)...)
+	}
 
-	*/
 	log.Printf("Done!")
+	return outcome
+}
+
+// RenderOne assembles an input and draws it as AssembleOne would, but only
+// returns the image
+func (t *Tiler) RenderOne(input string) (*image.RGBA, Outcome) {
+	im, outcome, _ := t.renderContext(context.Background(), input, 0)
+	return im, outcome
+}
+
+// renderContext is RenderOne, but gives up with ctx's error if ctx is done
+// before the image is, and fails rather than draw an assembly of more than
+// maxPixels pixels unless that's 0
+func (t *Tiler) renderContext(ctx context.Context, input string, maxPixels int) (*image.RGBA, Outcome, error) {
+	if !t.validInput(input) {
+		return nil, Outcome{Input: input, Failure: "invalid symbol in input"}, nil
+	}
+	var assembly Assembly
+	completed, err := t.assembleRowsContext(ctx, input, func(row []*Tile) { assembly = append(assembly, row) })
+	if err != nil {
+		return nil, Outcome{Input: input, Failure: err.Error()}, err
+	}
+	outcome := t.outcome(input, len(assembly)-1, tileRowConfig(assembly[len(assembly)-1]), completed)
+	sizeX, sizeY, rotated := t.computeRotated(len(assembly[0]), len(assembly), assembly)
+	if width, height := t.canvasSize(sizeX, sizeY); maxPixels > 0 && width*height > maxPixels {
+		outcome.Failure = fmt.Sprintf("the image would be %dx%d, more than %d pixels", width, height, maxPixels)
+		return nil, outcome, nil
+	}
+	im, err := t.drawAssembly(ctx, input, assembly, rotated)
+	if err != nil {
+		return nil, Outcome{Input: input, Failure: err.Error()}, err
+	}
+	return im, outcome, nil
+}
+
+// drawAssembly composites an assembly, with the trajectory and legend if they
+// are wanted, giving up with ctx's error if ctx is done first. rotated is the
+// assembly in its final orientation.
+func (t *Tiler) drawAssembly(ctx context.Context, input string, assembly, rotated Assembly) (*image.RGBA, error) {
+	log.Printf("Generating canvas...")
+	im, err := t.composite(ctx, len(rotated[0]), len(rotated), rotated)
+	if err != nil {
+		return nil, err
+	}
+	if t.Trajectory {
+		t.drawTrajectory(im, headTrail(assembly), len(assembly[0]), len(assembly))
+	}
+	if t.Legend {
+		im = t.addLegend(im, t.legendEntries(input, assembly))
+	}
+	return im, nil
+}
+
+func (t *Tiler) validInput(input string) bool {
+	symbolRx := regexp.MustCompile(fmt.Sprintf("[^%s]", string(t.Symbols)))
+	return !symbolRx.MatchString(input)
+}
+
+// seed the assembly with starter tiles generated from the input and assemble
+// until we hit a halting state or the depth limit is reached. only the top
+// three rows are kept while assembling, since tiles are only ever added to the
+// top two; each row below them is complete and is passed to emit, bottom row
+// first. returns false if the depth limit was hit.
+func (t *Tiler) assembleRows(input string, emit func(row []*Tile)) bool {
+	completed, _ := t.assembleRowsContext(context.Background(), input, emit)
+	return completed
+}
+
+// assembleRowsContext is assembleRows, but gives up with ctx's error as soon
+// as a row is finished after ctx is done
+func (t *Tiler) assembleRowsContext(ctx context.Context, input string, emit func(row []*Tile)) (bool, error) {
+	seed := t.seedRow(input)
+	log.Printf("Assembling transition tiles...")
+	return t.assembleFrom(ctx, Assembly{seed}, 0, emit, nil)
+}
+
+// seedRow makes the starter tiles of an input, with the boundary tiles either
+// side of it
+func (t *Tiler) seedRow(input string) []*Tile {
+	// annotate initial input with head semantics before generating starter tiles
+	cells := make([]Cell, 0, len(input))
+	for i, r := range []rune(input) {
+		cells = append(cells, Cell{r, i == t.InitialLocation})
+	}
+
+	log.Printf("Generating starter tiles...")
+
+	// seed first row of assembly with starter tiles from input-generated cells
+	seed := make([]*Tile, len(cells)+2)
+
+	// wrap with boundary symbols at beginning and end
+	seed[0] = t.cellToTile(&Cell{t.BoundarySymbol, false}, true, false)
+	for i, cell := range cells {
+		seed[i+1] = t.cellToTile(&cell, false, false)
+	}
+	seed[len(seed)-1] = t.cellToTile(&Cell{t.BoundarySymbol, false}, false, true)
+	return seed
+}
+
+// assembleFrom carries on assembling from the top rows of an assembly, with
+// emitted rows already passed to emit below them. save, if not nil, is passed
+// the top rows each time a row is emitted, to checkpoint them.
+func (t *Tiler) assembleFrom(ctx context.Context, window Assembly, emitted int, emit func(row []*Tile), save func(window Assembly)) (bool, error) {
+	for t.addTile(&window) {
+		if len(window) > 3 {
+			// the row under the top three is no longer needed to bond to
+			emit(window[0])
+			emitted++
+			window = Assembly{window[1], window[2], window[3]}
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+			if save != nil {
+				save(window)
+			}
+		}
+		// a row is complete once there are two above it, so carry on until
+		// the row after the last allowed step is, to tell it from a halt
+		if t.MaxDepth > 0 && emitted+len(window) > t.MaxDepth+2 {
+			break
+		}
+	}
+
+	// the seed row and a row for each of at most MaxDepth steps
+	for i, row := range window {
+		if t.MaxDepth > 0 && emitted+i+1 > t.MaxDepth+1 {
+			log.Printf("  Warning: assembly hit maximum depth (%d), increase with -max-depth", t.MaxDepth)
+			return false, nil
+		}
+		emit(row)
+	}
+	return true, nil
 }
 
 // starting with the current assembly, try to add any tile drawn from the pool
 // that fits in an empty spot adjacent to an existing tile. tiles may only be
 // added if at least two bonds are made in so doing (i.e. two single bonds or
 // one double bond).
-func (t *Tiler) addTile(assembly Assembly) bool {
-	// search over the entire current assembly
-	startY := len(assembly) - 2
+func (t *Tiler) addTile(assembly *Assembly) bool {
+	a := *assembly
+
+	// search over the top two rows of the current assembly; everything below
+	// them is already complete
+	startY := len(a) - 2
 	if startY < 0 {
 		startY = 0
 	}
-	for y := startY; y < len(assembly)-1; y++ {
-		for x := 0; x < len(assembly[y]); x++ {
-			// TODO: continue porting
-			/*
-			               my $tile = $assembly->[ $y ][ $x ]; // this tile
-			               next unless defined $tile;
-
-			   			ltile := assembly[y][x-1] // left neighbor
-			   			rtile := assembly[y][x+1] // right neighbor
-			   			ttile := assembly[y+1][ $x ] // top neighbor
-			   			lltile := assembly[y-1][x-1] // left and down
-			   			lrtile := assembly[y-1][x+1] // right and down
-
-			               // try to add tile left
-			               if (
-			                   !defined $ltile && // can't step on an existing tile
-			                   defined $lltile && // can only add left if left-down is there
-			                   (
-			                       $tile->{ sides }{ +LEFT }{ bond_strength } +
-			                       $lltile->{ sides }{ +TOP }{ bond_strength }
-			                   ) >= 2 // would-be bond sum is good enough
-			               ) {
-			                   // space looks good, search for a matching tile in the pool
-			                   my $stile = $tile_cache_right
-			                       { $tile->{ sides }{ +LEFT }{ label } }
-			                       { $lltile->{ sides }{ +TOP }{ label } };
-			                   if ( defined $stile ) {
-			                       // found a match, fill it in
-			                       $assembly->[ $y ][ $x - 1 ] = $stile;
-			                       return 1;
-			                   }
-			               }
-
-			               // try to add tile right
-			               if (
-			                   !defined $rtile && // can't step on an existing tile
-			                   defined $lrtile && // can only add right if right-down is there
-			                   (
-			                       $tile->{ sides }{ +RIGHT }{ bond_strength } +
-			                       $lrtile->{ sides }{ +TOP }{ bond_strength } >= 2
-			                   ) // would-be bond sum is good enough
-			               ) {
-			                   // space looks good, search for a matching tile in the pool
-			                   my $stile = $tile_cache_left
-			                       { $tile->{ sides }{ +RIGHT }{ label } }
-			                       { $lrtile->{ sides }{ +TOP }{ label } };
-			                   if ( defined $stile ) {
-			                       // found a match, fill it in
-			                       $assembly->[ $y ][ $x + 1 ] = $stile;
-			                       return 1;
-			                   }
-			               }
-
-			               // try to add tile up; adding vertically can ONLY happen by double-bond
-			               if (
-			                   !defined $ttile &&
-			                   $tile->{ sides }{ +TOP }{ bond_strength } >= 2
-			               ) {
-			                   my $stile = $tile_cache_bottom{ $tile->{ sides }{ +TOP }{ label } };
-			                   if ( defined $stile ) {
-			                       // found a match, fill it in
-			                       $assembly->[ $y + 1 ][ $x ] = $stile;
-			                       return 1;
-			                   }
-			               }
-			*/
+	for y := startY; y < len(a); y++ {
+		for x := 0; x < len(a[y]); x++ {
+			tile := a[y][x] // this tile
+			if tile == nil {
+				continue
+			}
+
+			var ltile, rtile, ttile, lltile, lrtile *Tile
+			if x > 0 {
+				ltile = a[y][x-1] // left neighbor
+				if y > 0 {
+					lltile = a[y-1][x-1] // left and down
+				}
+			}
+			if x < len(a[y])-1 {
+				rtile = a[y][x+1] // right neighbor
+				if y > 0 {
+					lrtile = a[y-1][x+1] // right and down
+				}
+			}
+			if y < len(a)-1 {
+				ttile = a[y+1][x] // top neighbor
+			}
+
+			// try to add tile left
+			if ltile == nil && // can't step on an existing tile
+				lltile != nil && // can only add left if left-down is there
+				tile.Sides[Left].Strength+lltile.Sides[Up].Strength >= 2 { // would-be bond sum is good enough
+				// space looks good, search for a matching tile in the pool
+				if stile, ok := t.tileIndexRight[twople{tile.Sides[Left].Label, lltile.Sides[Up].Label}]; ok {
+					// found a match, fill it in
+					a[y][x-1] = stile
+					return true
+				}
+			}
+
+			// try to add tile right
+			if rtile == nil && // can't step on an existing tile
+				lrtile != nil && // can only add right if right-down is there
+				tile.Sides[Right].Strength+lrtile.Sides[Up].Strength >= 2 { // would-be bond sum is good enough
+				// space looks good, search for a matching tile in the pool
+				if stile, ok := t.tileIndexLeft[twople{tile.Sides[Right].Label, lrtile.Sides[Up].Label}]; ok {
+					// found a match, fill it in
+					a[y][x+1] = stile
+					return true
+				}
+			}
+
+			// try to add tile up; adding vertically can ONLY happen by double-bond
+			if ttile == nil && tile.Sides[Up].Strength >= 2 {
+				if stile, ok := t.tileIndexBottom[tile.Sides[Up].Label]; ok {
+					// found a match, fill it in
+					if y == len(a)-1 {
+						a = append(a, make([]*Tile, len(a[y])))
+						*assembly = a
+					}
+					a[y+1][x] = stile
+					return true
+				}
+			}
 		}
 	}
 
@@ -266,7 +386,7 @@ func bondStrength(strong bool) int {
 func (t *Tiler) cellToTile(cell *Cell, left, right bool) *Tile {
 	var upLabel string
 	if cell.Head {
-		upLabel = fmt.Sprintf("%s %s", t.InitialState)
+		upLabel = fmt.Sprintf("%s %s", t.InitialState, string(cell.Symbol))
 	} else {
 		upLabel = string(cell.Symbol)
 	}
@@ -275,8 +395,8 @@ func (t *Tiler) cellToTile(cell *Cell, left, right bool) *Tile {
 		Sides: Bonds{
 			Up:    Bond{bondStrength(cell.Head), upLabel},
 			Down:  Bond{1, ""},
-			Left:  Bond{bondStrength(left), ""},
-			Right: Bond{bondStrength(right), ""},
+			Left:  Bond{bondStrength(!left), ""},
+			Right: Bond{bondStrength(!right), ""},
 		},
 	}
 	tile.Image = t.generateImage(&tile)
@@ -286,26 +406,24 @@ func (t *Tiler) cellToTile(cell *Cell, left, right bool) *Tile {
 func (t *Tiler) generateImage(tile *Tile) image.Image {
 	r := image.Rect(0, 0, t.TileWidth, t.TileHeight)
 	im := image.NewRGBA(r)
-	bgColor := t.getLabelColor(fmt.Sprintf("background%v", tile.Final), true)
+	bgColor := t.backgroundColor(tile.Final)
 	draw.Draw(im, im.Bounds(), &image.Uniform{bgColor}, image.ZP, draw.Src)
 
 	for _, side := range []Direction{Up, Down, Left, Right} {
 		strength := tile.Sides[side].Strength
 		label := tile.Sides[side].Label
 
-		orientedLabel := fmt.Sprintf("%d%s%d", side%2, label, strength)
-		color := t.getLabelColor(orientedLabel, false)
+		color := t.bondColor(side, tile.Sides[side])
 
 		rotSide := t.rotatedDirection(side)
-		t.drawBond(im, rotSide, strength, color)
-		t.drawString(im, rotSide, strength, color, label)
-	}
-	w, err := os.Create(fmt.Sprintf("%s.png", tile.Name))
-	if err != nil {
-		log.Fatal(err)
+		t.drawBond(im, rotSide, strength, color, t.bondPattern(side, tile.Sides[side]))
+		switch t.labelStyle {
+		case labelText:
+			t.drawString(im, rotSide, strength, color, label)
+		case labelBoxes:
+			draw.Draw(im, t.labelBounds(label, rotSide, strength), &image.Uniform{color}, image.ZP, draw.Src)
+		}
 	}
-	defer w.Close()
-	png.Encode(w, im)
 	return im
 }
 
@@ -317,18 +435,19 @@ func (t *Tiler) getLabelColor(label string, bright bool) color.RGBA {
 	}
 
 	// use a hash function to get some deterministic but random-looking data
-	// based on the label. here we get 32 bytes from MD5 and unpack the first
-	// 24 as uint64's.
-	var i1, i2, i3 uint64
-	hash := md5.Sum([]byte(label + t.ColorTweak))
+	// based on the label. here we unpack the first 12 bytes of its MD5 as
+	// uint32's. assemble.pl called Digest::MD5->md5 as a method, which hashes
+	// the class name too; doing the same keeps the colors of its drawings.
+	var i1, i2, i3 uint32
+	hash := md5.Sum([]byte("Digest::MD5" + label + t.ColorTweak))
 	buf := bytes.NewReader(hash[:])
 	binary.Read(buf, binary.LittleEndian, &i1)
 	binary.Read(buf, binary.LittleEndian, &i2)
 	binary.Read(buf, binary.LittleEndian, &i3)
 
-	r1 := float64(i1) / float64(MaxUint64)
-	r2 := float64(i2) / float64(MaxUint64)
-	r3 := float64(i3) / float64(MaxUint64)
+	r1 := float64(i1) / (1 << 32)
+	r2 := float64(i2) / (1 << 32)
+	r3 := float64(i3) / (1 << 32)
 
 	h, s, v := r1, 0.0, 0.0
 	if bright {
@@ -365,7 +484,7 @@ func (t *Tiler) getLabelColor(label string, bright bool) color.RGBA {
 	g = 255 * Max(0, Min(1, g))
 	b = 255 * Max(0, Min(1, b))
 
-	c := color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b)}
+	c := color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: 255}
 	if t.colors == nil {
 		t.colors = make(map[string]color.RGBA)
 	}
@@ -373,29 +492,57 @@ func (t *Tiler) getLabelColor(label string, bright bool) color.RGBA {
 	return c
 }
 
-func (t *Tiler) drawBond(im draw.Image, side Direction, strength int, color color.RGBA) {
+func (t *Tiler) drawBond(im draw.Image, side Direction, strength int, color color.RGBA, pattern int) {
+	for _, r := range patternRects(t.bondRects(side, strength), side, pattern) {
+		draw.Draw(im, r, &image.Uniform{color}, image.ZP, draw.Src)
+	}
+}
+
+// the bars making up a bond on the given (already rotated) side of a tile
+func (t *Tiler) bondRects(side Direction, strength int) []image.Rectangle {
+	var rects []image.Rectangle
 	for i := 0; i < strength; i++ {
-		var r image.Rectangle
+		// bounds are inclusive, so that a zero fudge still draws a line
+		var x0, y0, x1, y1 float64
+		vert, horiz := float64(i)*t.tileVertShift, float64(i)*t.tileHorizShift
+		fudgeX, fudgeY := float64(t.bondFudgeX), float64(t.bondFudgeY)
 		switch side {
 		case Up:
-			r = image.Rect(0, i*t.tileVertShift-t.bondFudgeY,
-				t.TileWidth-1, i*t.tileVertShift+t.bondFudgeY)
+			x0, y0 = 0, vert-fudgeY
+			x1, y1 = float64(t.TileWidth-1), vert+fudgeY
 		case Down:
-			r = image.Rect(0, t.TileHeight-1-i*t.tileVertShift-t.bondFudgeY,
-				t.TileWidth-1, t.TileHeight-1-i*t.tileVertShift+t.bondFudgeY)
+			x0, y0 = 0, float64(t.TileHeight-1)-vert-fudgeY
+			x1, y1 = float64(t.TileWidth-1), float64(t.TileHeight-1)-vert+fudgeY
 		case Left:
-			r = image.Rect(i*t.tileHorizShift-t.bondFudgeX, 0,
-				i*t.tileHorizShift+t.bondFudgeX, t.TileHeight-1)
+			x0, y0 = horiz-fudgeX, 0
+			x1, y1 = horiz+fudgeX, float64(t.TileHeight-1)
 		case Right:
-			r = image.Rect(t.TileWidth-1-i*t.tileHorizShift-t.bondFudgeX, 0,
-				t.TileWidth-1-i*t.tileHorizShift+t.bondFudgeX, t.TileHeight-1)
+			x0, y0 = float64(t.TileWidth-1)-horiz-fudgeX, 0
+			x1, y1 = float64(t.TileWidth-1)-horiz+fudgeX, float64(t.TileHeight-1)
 		}
-		draw.Draw(im, r, &image.Uniform{color}, image.ZP, draw.Src)
+		rects = append(rects, image.Rect(int(x0), int(y0), int(x1)+1, int(y1)+1))
 	}
+	return rects
+}
+
+// sides in counterclockwise order, so that each step of Rotation turns a tile
+// 90 degrees counterclockwise
+var ccwDirections = []Direction{Up, Left, Down, Right}
+
+func axis(side Direction) int {
+	if side == Up || side == Down {
+		return 0
+	}
+	return 1
 }
 
 func (t *Tiler) rotatedDirection(side Direction) Direction {
-	side = Direction((int(side) + t.Rotation) % 4)
+	for i, d := range ccwDirections {
+		if d == side {
+			side = ccwDirections[(i+t.Rotation)%4]
+			break
+		}
+	}
 	if t.FlipHorizontal {
 		if side == Left {
 			side = Right
@@ -413,78 +560,280 @@ func (t *Tiler) rotatedDirection(side Direction) Direction {
 	return side
 }
 
-func (t *Tiler) drawString(im *image.RGBA, side Direction, strength int, color color.RGBA, str string) {
+// the space a label may fill: up and down labels span the width of the tile,
+// left and right ones share its middle third
+func (t *Tiler) labelBox(side Direction, strength int) (float64, float64) {
 	bondShift := strength - 1
-	var x, y int
+	switch side {
+	case Up, Down:
+		return float64(t.TileWidth) - 2*t.tileHorizMargin - 2,
+			float64(t.TileHeight)/3 - t.tileVertMargin - float64(bondShift)*t.tileVertShift
+	}
+	return float64(t.TileWidth)/2 - t.tileHorizMargin - float64(bondShift)*t.tileHorizShift - 1,
+		float64(t.TileHeight) / 3
+}
+
+// the largest size up to FontSize at which a label fits its box, but no
+// smaller than MinFontSize
+func (t *Tiler) labelFontSize(str string, side Direction, strength int) float64 {
+	w, h := t.labelBox(side, strength)
+	size := Min(t.FontSize, h/capHeight)
+	if width := t.textWidth(str, size); width > w {
+		size *= w / width
+	}
+	return Max(size, t.MinFontSize)
+}
+
+// autoSize picks the smallest tile size at which every label in the pool fits
+// at FontSize, widened or heightened to keep a 4:3 aspect ratio
+func (t *Tiler) autoSize() {
+	// margins and shifts are fractions of the tile size, so each box is too
+	var width, height float64
+	for _, tile := range t.tiles {
+		for side, bond := range tile.Sides {
+			if bond.Label == "" {
+				continue
+			}
+			shift := float64(bond.Strength - 1)
+			label := t.textWidth(bond.Label, t.FontSize)
+			high := capHeight * t.FontSize
+			switch t.rotatedDirection(side) {
+			case Up, Down:
+				width = Max(width, (label+2)/0.9)
+				height = Max(height, high/(1.0/3-0.05-0.1*shift))
+			default:
+				width = Max(width, (label+1)/(0.45-0.1*shift))
+				height = Max(height, high*3)
+			}
+		}
+	}
+	width = Max(width, height*4/3)
+	t.TileWidth = int(Ceil(width))
+	t.TileHeight = int(Ceil(width * 3 / 4))
+	t.setupMetrics()
+	log.Printf("Sized tiles to %dx%d", t.TileWidth, t.TileHeight)
+}
+
+func (t *Tiler) drawString(im *image.RGBA, side Direction, strength int, color color.RGBA, str string) {
+	x, y, size := t.labelOrigin(str, side, strength)
+	t.drawText(im, color, str, size, int(Floor(x+0.5)), int(Floor(y+0.5)))
+}
+
+// labelOrigin works out the left end of a label's baseline and the size it's
+// drawn at
+func (t *Tiler) labelOrigin(str string, side Direction, strength int) (x, y, size float64) {
+	x, y, size, anchor := t.labelAnchor(str, side, strength)
+	switch anchor {
+	case "middle":
+		x -= t.textWidth(str, size) / 2
+	case "end":
+		x -= t.textWidth(str, size)
+	}
+	return x, y, size
+}
+
+// labelBounds is the box a label's glyphs are drawn in: each the size of a
+// '5' for classic labels, as assemble.pl took them to be, and otherwise
+// reaching from cap height to a little under the baseline
+func (t *Tiler) labelBounds(str string, side Direction, strength int) image.Rectangle {
+	x, y, size := t.labelOrigin(str, side, strength)
+	if str == "" {
+		return image.Rectangle{}
+	}
+	if t.ClassicLabels {
+		fontW, fontH, fontY := t.classicCharBox(size)
+		return image.Rect(int(x), int(y)+fontY, int(x)+len(str)*fontW, int(y)+fontY+fontH)
+	}
+	return image.Rect(int(Floor(x)), int(Floor(y-capHeight*size)),
+		int(Ceil(x+t.textWidth(str, size))), int(Ceil(y+(1-capHeight)*size/2)))
+}
+
+// labelAnchor works out the point on a label's baseline it's placed by, the
+// size it's drawn at, and which part of the label the point is: its "start",
+// "middle" or "end", as svg's text-anchor has it
+func (t *Tiler) labelAnchor(str string, side Direction, strength int) (x, y, size float64, anchor string) {
+	if t.ClassicLabels {
+		x, y, size = t.classicLabelOrigin(str, side, strength)
+		return x, y, size, "start"
+	}
+	bondShift := float64(strength - 1)
+	size = t.labelFontSize(str, side, strength)
+	height := capHeight * size
+
 	switch side {
 	case Up:
-		y = t.tileVertMargin + bondShift*t.tileVertShift
-		x = int((t.TileWidth - len(str)*t.fontWidth) / 2)
+		y = t.tileVertMargin + bondShift*t.tileVertShift + height
+		x, anchor = float64(t.TileWidth)/2, "middle"
 	case Down:
-		y = t.TileHeight - t.fontHeight - t.tileVertMargin - bondShift*t.tileVertShift
-		x = int((t.TileWidth - len(str)*t.fontWidth) / 2)
+		y = float64(t.TileHeight) - t.tileVertMargin - bondShift*t.tileVertShift
+		x, anchor = float64(t.TileWidth)/2, "middle"
 	case Left:
-		y = int((t.TileHeight - t.fontHeight) / 2)
+		y = (float64(t.TileHeight) + height) / 2
+		x, anchor = t.tileHorizMargin+bondShift*t.tileHorizShift, "start"
+	case Right:
+		y = (float64(t.TileHeight) + height) / 2
+		x, anchor = float64(t.TileWidth)-t.tileHorizMargin-bondShift*t.tileHorizShift, "end"
+	}
+	return x, y, size, anchor
+}
+
+// classicLabelOrigin places a label as assemble.pl did: every character is
+// taken to be as big as gd's bounding box for a '5', and coordinates are
+// truncated
+func (t *Tiler) classicLabelOrigin(str string, side Direction, strength int) (x, y, size float64) {
+	bondShift := float64(strength - 1)
+	size = t.FontSize
+	fontW, fontH, fontY := t.classicCharBox(size)
+	width := float64(len(str) * fontW)
+
+	switch side {
+	case Up:
+		y = t.tileVertMargin + bondShift*t.tileVertShift - float64(fontY)
+		x = float64(int((float64(t.TileWidth) - width) / 2))
+	case Down:
+		y = float64(t.TileHeight-fontH) - t.tileVertMargin - bondShift*t.tileVertShift - float64(fontY)
+		x = float64(int((float64(t.TileWidth) - width) / 2))
+	case Left:
+		y = float64(int(float64(t.TileHeight-fontH)/2) - fontY)
 		x = t.tileHorizMargin + bondShift*t.tileHorizShift
 	case Right:
-		y = int((t.TileHeight - t.fontHeight) / 2)
-		x = t.TileWidth - t.tileHorizMargin - len(str)*t.fontWidth - bondShift*t.tileHorizShift
+		y = float64(int(float64(t.TileHeight-fontH)/2) - fontY)
+		x = float64(t.TileWidth) - t.tileHorizMargin - width - bondShift*t.tileHorizShift
 	}
+	return Floor(x), Floor(y), size
+}
 
-	ctx := t.newTypeContext(im, color)
-	pt := freetype.Pt(x, y)
-	ctx.DrawString(str, pt)
+// the width and height of gd's bounding box for a '5' at a size, and the
+// offset of its top from the baseline. gd pads the advance and the ink above
+// the baseline by a pixel each.
+func (t *Tiler) classicCharBox(size float64) (w, h, y int) {
+	font := t.fontFor('5')
+	fupe := font.FUnitsPerEm()
+	index := font.Index('5')
+	g := truetype.NewGlyphBuf()
+	if err := g.Load(font, fupe, index, nil); err != nil {
+		log.Panicf("Couldn't load the glyph for '5': %s", err)
+	}
+	scale := size / float64(fupe)
+	w = int(Floor(float64(font.HMetric(fupe, index).AdvanceWidth)*scale+0.5)) + 1
+	top := int(Ceil(float64(g.B.YMax)*scale)) + 1
+	h = top + int(Ceil(-float64(g.B.YMin)*scale))
+	return w, h, -top
 }
 
-// rotate the @assembly matrix
+// rotate the assembly matrix
 func (t *Tiler) computeRotated(sizeX, sizeY int, assembly Assembly) (int, int, Assembly) {
-	return sizeX, sizeY, assembly
-	/*
-	   my $old_assembly = shift;
-	   my $size_x = shift;
-	   my $size_y = shift;
-	   my $new_assembly;
-
-	   for my $j ( 0 .. $size_y - 1 ) {
-	       for my $i ( 0 .. $size_x - 1 ) {
-	           if ( $rotation == 0 ) {
-	               // no rotation, but possibly flips
-	               my $ti = $i;
-	               my $tj = $j;
-	               $ti = $size_x - 1 - $ti if     $flip_horiz;
-	               $tj = $size_y - 1 - $tj if     $flip_vert;
-	               $new_assembly->[ $tj ][ $ti ] = $old_assembly->[ $j ][ $i ];
-	           }
-	           elsif ( $rotation == 1 ) {
-	               // CCW 90
-	               my $ti = $i;
-	               my $tj = $j;
-	               $ti = $size_x - 1 - $ti if     $flip_horiz;
-	               $tj = $size_y - 1 - $tj unless $flip_vert;
-	               $new_assembly->[ $ti ][ $tj ] = $old_assembly->[ $j ][ $i ];
-	           }
-	           elsif ( $rotation == 2 ) {
-	               // 180
-	               my $ti = $i;
-	               my $tj = $j;
-	               $ti = $size_x - 1 - $ti unless $flip_horiz;
-	               $tj = $size_y - 1 - $tj unless $flip_vert;
-	               $new_assembly->[ $tj ][ $ti ] = $old_assembly->[ $j ][ $i ];
-	           }
-	           elsif ( $rotation == 3 ) {
-	               // CW 90
-	               my $ti = $i;
-	               my $tj = $j;
-	               $ti = $size_x - 1 - $ti unless $flip_horiz;
-	               $tj = $size_y - 1 - $tj if     $flip_vert;
-	               $new_assembly->[ $ti ][ $tj ] = $old_assembly->[ $j ][ $i ];
-	           }
-	       }
-	   }
-
-	   if ( $rotation % 2 == 1 ) {
-	       ( $size_x, $size_y ) = ( $size_y, $size_x );
-	   }
-	   return $size_x, $size_y, @$new_assembly;
-	*/
+	newX, newY := sizeX, sizeY
+	if t.Rotation%2 == 1 {
+		newX, newY = sizeY, sizeX
+	}
+	rotated := make(Assembly, newY)
+	for j := range rotated {
+		rotated[j] = make([]*Tile, newX)
+	}
+
+	for j := 0; j < sizeY; j++ {
+		for i := 0; i < sizeX; i++ {
+			tj, ti := t.rotatedPosition(j, i, sizeX, sizeY)
+			rotated[tj][ti] = assembly[j][i]
+		}
+	}
+
+	return newX, newY, rotated
+}
+
+// where row j, column i of an assembly ends up after rotation
+func (t *Tiler) rotatedPosition(j, i, sizeX, sizeY int) (int, int) {
+	ti, tj := i, j
+	switch t.Rotation {
+	case 0:
+		// no rotation, but possibly flips
+		if t.FlipHorizontal {
+			ti = sizeX - 1 - ti
+		}
+		if t.FlipVertical {
+			tj = sizeY - 1 - tj
+		}
+		return tj, ti
+	case 1:
+		// CCW 90
+		if t.FlipHorizontal {
+			ti = sizeX - 1 - ti
+		}
+		if !t.FlipVertical {
+			tj = sizeY - 1 - tj
+		}
+		return ti, tj
+	case 2:
+		// 180
+		if !t.FlipHorizontal {
+			ti = sizeX - 1 - ti
+		}
+		if !t.FlipVertical {
+			tj = sizeY - 1 - tj
+		}
+		return tj, ti
+	case 3:
+		// CW 90
+		if !t.FlipHorizontal {
+			ti = sizeX - 1 - ti
+		}
+		if t.FlipVertical {
+			tj = sizeY - 1 - tj
+		}
+		return ti, tj
+	}
+	log.Panicf("Rotation must be from 0-3, not %d", t.Rotation)
+	return 0, 0
+}
+
+// the size of the master canvas for an assembly of sizeX by sizeY tiles
+func (t *Tiler) canvasSize(sizeX, sizeY int) (int, int) {
+	return t.TileWidth*sizeX - sizeX + 1, t.TileHeight*sizeY - sizeY + 1
+}
+
+// copy component tiles to a master canvas containing the record of the entire
+// computation, giving up with ctx's error if ctx is done first
+func (t *Tiler) composite(ctx context.Context, sizeX, sizeY int, assembly Assembly) (*image.RGBA, error) {
+	width, height := t.canvasSize(sizeX, sizeY)
+	return t.renderRegionContext(ctx, sizeX, sizeY, assembly, image.Rect(0, 0, width, height))
+}
+
+// draw just the part of the master canvas within r. neighboring tiles overlap
+// by one pixel so that their bonds coincide; upper and right tiles are drawn
+// last. row 0 of the assembly is drawn at the bottom.
+func (t *Tiler) renderRegion(sizeX, sizeY int, assembly Assembly, r image.Rectangle) *image.RGBA {
+	im, _ := t.renderRegionContext(context.Background(), sizeX, sizeY, assembly, r)
+	return im
+}
+
+// renderRegionContext is renderRegion, but gives up with ctx's error if ctx is
+// done first
+func (t *Tiler) renderRegionContext(ctx context.Context, sizeX, sizeY int, assembly Assembly, r image.Rectangle) (*image.RGBA, error) {
+	target := image.NewRGBA(r)
+
+	// only visit tiles that could overlap the region
+	firstRow := sizeY - 1 - r.Max.Y/(t.TileHeight-1)
+	lastRow := sizeY - 1 - (r.Min.Y-t.TileHeight)/(t.TileHeight-1)
+	firstCol := (r.Min.X - t.TileWidth) / (t.TileWidth - 1)
+	lastCol := r.Max.X / (t.TileWidth - 1)
+
+	for i := firstRow; i <= lastRow; i++ {
+		if i < 0 || i >= len(assembly) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for j := firstCol; j <= lastCol; j++ {
+			if j < 0 || j >= len(assembly[i]) || assembly[i][j] == nil {
+				continue // silently ignore missing tiles
+			}
+			x := t.TileWidth*j - j
+			y := t.TileHeight*(sizeY-i-1) - (sizeY - i - 1)
+			tr := image.Rect(x, y, x+t.TileWidth, y+t.TileHeight)
+			draw.Draw(target, tr, assembly[i][j].Image, image.ZP, draw.Src)
+		}
+	}
+	return target, nil
 }
@@ -0,0 +1,173 @@
+package tiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Case is an input listed in an inputs file, with what running the machine on
+// it should do. a case with no expectations should still halt.
+type Case struct {
+	Input              string
+	Output, Tape       *string // nil if not given
+	MinSteps, MaxSteps int     // MaxSteps is 0 if unbounded
+
+	// how the case's golden image was drawn, beyond the options every golden
+	// is drawn with, and how closely it's checked
+	Theme          string // relative to the inputs file
+	FlipHorizontal bool
+	IgnoreLabels   bool    // for goldens drawn when the labels were different
+	Tolerance      float64 // 0 for the golden command's
+}
+
+var (
+	inputsCommentRx = regexp.MustCompile("#.*")
+	inputsStepsRx   = regexp.MustCompile(`^(\d*)(?:\.\.(\d*))?$`)
+)
+
+// readInputs reads the cases in an inputs file, or none if there's no such
+// file. each line holds an input, optionally followed by expectations:
+//
+//	aabbcc output=Yes tape=dddddd steps=20..40
+//
+// output is the halting output, "" for none, and tape is the final tape
+// without its boundary cells. steps is an exact count or a range with either
+// end left open. theme and flip-horizontal=true say how the case's golden
+// image was drawn, labels=false that its labels aren't to be compared, and
+// tolerance how much of it may differ. anything after a # is a comment.
+func readInputs(file string) []Case {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Panicf("Couldn't open %s: %s", file, err)
+	}
+	defer f.Close()
+
+	var cases []Case
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(inputsCommentRx.ReplaceAllString(scanner.Text(), ""))
+		if len(fields) == 0 {
+			continue
+		}
+		c := Case{Input: fields[0]}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				log.Panicf("%s:%d: expected key=value, got %q", file, n, field)
+			}
+			value := kv[1]
+			switch kv[0] {
+			case "output":
+				c.Output = &value
+			case "tape":
+				c.Tape = &value
+			case "steps":
+				m := inputsStepsRx.FindStringSubmatch(value)
+				if m == nil || value == "" || value == ".." {
+					log.Panicf("%s:%d: steps should be N, N..M, N.. or ..M, not %q", file, n, value)
+				}
+				c.MinSteps, _ = strconv.Atoi(m[1])
+				c.MaxSteps = c.MinSteps
+				if strings.Contains(value, "..") {
+					c.MaxSteps, _ = strconv.Atoi(m[2])
+				}
+			case "theme":
+				c.Theme = value
+			case "flip-horizontal":
+				if c.FlipHorizontal, err = strconv.ParseBool(value); err != nil {
+					log.Panicf("%s:%d: flip-horizontal should be true or false, not %q", file, n, value)
+				}
+			case "labels":
+				labels, err := strconv.ParseBool(value)
+				if err != nil {
+					log.Panicf("%s:%d: labels should be true or false, not %q", file, n, value)
+				}
+				c.IgnoreLabels = !labels
+			case "tolerance":
+				if c.Tolerance, err = strconv.ParseFloat(value, 64); err != nil {
+					log.Panicf("%s:%d: tolerance should be a fraction, not %q", file, n, value)
+				}
+			default:
+				log.Panicf("%s:%d: unknown expectation %q, expected output, tape, steps, theme, flip-horizontal, labels or tolerance", file, n, kv[0])
+			}
+		}
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Panicf("Couldn't read %s: %s", file, err)
+	}
+	return cases
+}
+
+// Check runs the simulator on a case and returns how the run differed from
+// what was expected, if at all
+func (t *Tiler) Check(c Case) []string {
+	if !t.validInput(c.Input) {
+		return []string{"invalid symbol in input"}
+	}
+	var last Config
+	steps := -1
+	completed := t.simulateRows(c.Input, func(conf *Config) {
+		last = *conf
+		steps++
+	})
+
+	var problems []string
+	if outcome := t.outcome(c.Input, steps, &last, completed); outcome.Failure != "" {
+		problems = append(problems, outcome.Failure)
+	}
+	if c.Output != nil && last.Halted && last.Output != *c.Output {
+		problems = append(problems, fmt.Sprintf("output %q, expected %q", last.Output, *c.Output))
+	}
+	if c.Tape != nil {
+		tape := string(last.Tape[1 : len(last.Tape)-1])
+		if tape != *c.Tape {
+			problems = append(problems, fmt.Sprintf("tape %q, expected %q", tape, *c.Tape))
+		}
+	}
+	if steps < c.MinSteps || (c.MaxSteps > 0 && steps > c.MaxSteps) {
+		bound := strconv.Itoa(c.MinSteps)
+		if c.MaxSteps != c.MinSteps {
+			bound = fmt.Sprintf("%d..", c.MinSteps)
+			if c.MaxSteps > 0 {
+				bound += strconv.Itoa(c.MaxSteps)
+			}
+		}
+		problems = append(problems, fmt.Sprintf("%d steps, expected %s", steps, bound))
+	}
+	return problems
+}
+
+// TestExamples checks every case of every example against the simulator,
+// writing a line per case to w. it returns the number of cases which failed.
+func (o *Options) TestExamples(examples []Example, w io.Writer) int {
+	failures, cases := 0, 0
+	for i := range examples {
+		e := &examples[i]
+		if len(e.Cases) == 0 {
+			continue
+		}
+		options := o.forExample(e)
+		t := options.NewMachineTiler()
+		for _, c := range e.Cases {
+			cases++
+			problems := t.Check(c)
+			if len(problems) == 0 {
+				fmt.Fprintf(w, "ok    %s %s\n", e.MachineFile, c.Input)
+				continue
+			}
+			failures++
+			fmt.Fprintf(w, "FAIL  %s %s: %s\n", e.MachineFile, c.Input, strings.Join(problems, "; "))
+		}
+	}
+	fmt.Fprintf(w, "%d cases, %d failed\n", cases, failures)
+	return failures
+}
@@ -0,0 +1,171 @@
+package tiler
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+// one line of a legend: text, optionally after a sample of a bond
+type legendEntry struct {
+	text  string
+	color color.RGBA
+	bond  *Bond
+	side  Direction
+}
+
+// legendEntries describes an unrotated assembly: the machine, input and
+// outcome, the states passed through, and every bond label that appears
+func (t *Tiler) legendEntries(input string, assembly Assembly) []legendEntry {
+	black := color.RGBA{0, 0, 0, 255}
+	last := tileRowConfig(assembly[len(assembly)-1])
+	outcome := "did not halt"
+	if last.Halted {
+		outcome = "halted"
+		if last.Output != "" {
+			outcome += " with output " + last.Output
+		}
+	}
+	entries := []legendEntry{
+		{text: fmt.Sprintf("%s: %s", t.Name, input), color: black},
+		{text: fmt.Sprintf("%d steps, %s", len(assembly)-1, outcome), color: black},
+	}
+
+	type key struct {
+		axis  int
+		label string
+		str   int
+	}
+	seen := make(map[key]bool)
+	var bonds []key
+	states := make(map[string]bool)
+	for _, row := range assembly {
+		for _, tile := range row {
+			if tile == nil {
+				continue
+			}
+			for side, bond := range tile.Sides {
+				k := key{axis(side), bond.Label, bond.Strength}
+				if bond.Label == "" || seen[k] {
+					continue
+				}
+				seen[k] = true
+				bonds = append(bonds, k)
+			}
+			if c := tileTextCell(tile); c != nil && c.head {
+				states[c.state] = true
+			}
+		}
+	}
+
+	var names []string
+	for s := range states {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+	entries = append(entries, legendEntry{text: "states:", color: black})
+	for _, s := range names {
+		entries = append(entries, legendEntry{text: "  " + s, color: t.bondColor(Left, Bond{1, s})})
+	}
+
+	// states and the head's direction run across, symbols up
+	sort.Slice(bonds, func(i, j int) bool {
+		a, b := bonds[i], bonds[j]
+		if a.axis != b.axis {
+			return a.axis > b.axis
+		}
+		if a.label != b.label {
+			return a.label < b.label
+		}
+		return a.str < b.str
+	})
+	entries = append(entries, legendEntry{text: "bonds:", color: black})
+	for _, k := range bonds {
+		side, dir := Up, "vertical"
+		if k.axis == axis(Left) {
+			side, dir = Left, "horizontal"
+		}
+		bond := Bond{k.str, k.label}
+		text := fmt.Sprintf("%s (%s)", k.label, dir)
+		if k.str > 1 {
+			text = fmt.Sprintf("%s (%s, double)", k.label, dir)
+		}
+		entries = append(entries, legendEntry{text: text, color: t.bondColor(side, bond), bond: &bond, side: side})
+	}
+	return entries
+}
+
+const (
+	legendPadding = 8
+	legendSwatch  = 24 // width of a bond sample
+)
+
+// addLegend draws a legend below an image, or beside it when the image is
+// landscape, flowing the entries into as many columns as fit alongside
+func (t *Tiler) addLegend(im *image.RGBA, entries []legendEntry) *image.RGBA {
+	size := t.FontSize
+	line := int(math.Ceil(size * 1.5))
+	colWidth := 0
+	for _, e := range entries {
+		w := int(math.Ceil(t.textWidth(e.text, size)))
+		if e.bond != nil {
+			w += legendSwatch + legendPadding
+		}
+		if w > colWidth {
+			colWidth = w
+		}
+	}
+	colWidth += 2 * legendPadding
+
+	b := im.Bounds()
+	var cols, rows int
+	side := t.Rotation%2 == 1
+	if side {
+		rows = (b.Dy() - 2*legendPadding) / line
+		if rows < 1 {
+			rows = 1
+		}
+		cols = (len(entries) + rows - 1) / rows
+	} else {
+		cols = b.Dx() / colWidth
+		if cols < 1 {
+			cols = 1
+		}
+		rows = (len(entries) + cols - 1) / cols
+	}
+	panelW, panelH := cols*colWidth, rows*line+2*legendPadding
+
+	var out *image.RGBA
+	var origin image.Point
+	if side {
+		out = image.NewRGBA(image.Rect(0, 0, b.Dx()+panelW, max(b.Dy(), panelH)))
+		origin = image.Pt(b.Dx(), 0)
+	} else {
+		out = image.NewRGBA(image.Rect(0, 0, max(b.Dx(), panelW), b.Dy()+panelH))
+		origin = image.Pt(0, b.Dy())
+	}
+	draw.Draw(out, out.Bounds(), image.White, image.ZP, draw.Src)
+	draw.Draw(out, b.Sub(b.Min), im, b.Min, draw.Src)
+
+	for i, e := range entries {
+		x := origin.X + i/rows*colWidth + legendPadding
+		y := origin.Y + legendPadding + i%rows*line
+		if e.bond != nil {
+			// a sample of the bond as it's drawn along the top of a tile
+			var bars []image.Rectangle
+			for s := 0; s < e.bond.Strength; s++ {
+				top := y + line/2 - 2*e.bond.Strength + 4*s
+				bars = append(bars, image.Rect(x, top, x+legendSwatch, top+2))
+			}
+			for _, r := range patternRects(bars, Up, t.bondPattern(e.side, *e.bond)) {
+				draw.Draw(out, r, image.NewUniform(e.color), image.ZP, draw.Src)
+			}
+			x += legendSwatch + legendPadding
+		}
+		t.drawText(out, e.color, e.text, size, x, y+int(float64(line)+capHeight*size)/2)
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package tiler
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Lint checks MachineFile for mistakes that parse but make for a machine that
+// can't run as intended, printing one line per problem to w. leaving out
+// transitions which are never needed is fine, so only with complete set is
+// every reachable state checked for a transition on every symbol. it returns
+// the number of problems found.
+func (o *Options) Lint(w io.Writer, complete bool) int {
+	t := o.NewMachineTiler()
+	problems := 0
+	report := func(format string, args ...interface{}) {
+		fmt.Fprintf(w, "%s: %s\n", t.MachineFile, fmt.Sprintf(format, args...))
+		problems++
+	}
+
+	// the parser appends the boundary symbol, so it may be declared twice
+	declared := make(map[string]bool)
+	var symbols []string
+	for i, symbol := range t.Symbols {
+		if declared[string(symbol)] {
+			if i < len(t.Symbols)-1 {
+				report("symbol %s is declared more than once", string(symbol))
+			}
+			continue
+		}
+		declared[string(symbol)] = true
+		symbols = append(symbols, string(symbol))
+	}
+
+	seen := make(map[twople]*Transition)
+	states := make(map[string]bool)
+	next := make(map[string][]string)
+	halts := false
+	for i := range t.Transitions {
+		trans := &t.Transitions[i]
+		for _, symbol := range []string{trans.ReadSymbol, trans.WriteSymbol} {
+			switch {
+			case utf8.RuneCountInString(symbol) != 1:
+				report("transition %s uses %q, but symbols are single characters", trans, symbol)
+			case !declared[symbol]:
+				report("transition %s uses symbol %s, which isn't declared", trans, symbol)
+			}
+		}
+		key := twople{trans.OldState, trans.ReadSymbol}
+		if first, exists := seen[key]; exists {
+			report("transitions %s and %s both apply in state %s on %s", first, trans, trans.OldState, trans.ReadSymbol)
+		} else {
+			seen[key] = trans
+		}
+		states[trans.OldState] = true
+		if trans.Move == Halt {
+			halts = true
+		} else {
+			states[trans.NewState] = true
+			next[trans.OldState] = append(next[trans.OldState], trans.NewState)
+		}
+	}
+	if !halts {
+		report("no transition halts, so no assembly can complete")
+	}
+
+	if !states[t.InitialState] {
+		report("start state %s has no transitions", t.InitialState)
+	}
+	reachable := map[string]bool{t.InitialState: true}
+	for queue := []string{t.InitialState}; len(queue) > 0; queue = queue[1:] {
+		for _, state := range next[queue[0]] {
+			if !reachable[state] {
+				reachable[state] = true
+				queue = append(queue, state)
+			}
+		}
+	}
+
+	var names []string
+	for state := range states {
+		names = append(names, state)
+	}
+	sort.Strings(names)
+	for _, state := range names {
+		if !reachable[state] {
+			report("state %s can't be reached from start state %s", state, t.InitialState)
+			continue
+		}
+		for _, symbol := range symbols {
+			if complete && seen[twople{state, symbol}] == nil {
+				report("state %s has no transition on %s", state, symbol)
+			}
+		}
+	}
+	if t.States > 0 && len(states) != t.States {
+		report("STATES declares %d states, but transitions use %d", t.States, len(states))
+	}
+	if t.States > 0 {
+		for _, state := range names {
+			if n, err := strconv.Atoi(state); err != nil || n < 1 || n > t.States {
+				report("state %s isn't numbered from 1 to %d as STATES declares", state, t.States)
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,62 @@
+package tiler
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// names the main image of a run; other outputs add a suffix before the extension
+const DefaultNameTemplate = "{name}-{input}.{format}"
+
+// outputFile names a file to write for an input by expanding NameTemplate,
+// with a suffix such as "-report" inserted before the extension, and creates
+// any directories it needs under OutputDir. it panics rather than return a
+// path outside OutputDir.
+func (t *Tiler) outputFile(input, suffix, format string) string {
+	template := t.NameTemplate
+	if template == "" {
+		template = DefaultNameTemplate
+	}
+	// the format is all that tells a run's outputs apart, so a template
+	// without it has its extension replaced
+	if !strings.Contains(template, "{format}") {
+		if ext := filepath.Ext(template); !strings.Contains(ext, "{") {
+			template = strings.TrimSuffix(template, ext)
+		}
+		template += ".{format}"
+	}
+	// values can't add directories, only the template can
+	clean := strings.NewReplacer("/", "_", "\\", "_").Replace
+	name := strings.NewReplacer(
+		"{name}", clean(t.Name),
+		"{input}", clean(input),
+		"{rotation}", strconv.Itoa(t.Rotation),
+		"{format}", format,
+	).Replace(template)
+	if suffix != "" {
+		ext := filepath.Ext(name)
+		name = strings.TrimSuffix(name, ext) + suffix + ext
+	}
+	return t.outputPath(name)
+}
+
+// outputPath places a file name under OutputDir, creating any directories it
+// needs, and panics rather than return a path outside it
+func (t *Tiler) outputPath(name string) string {
+	dir := t.OutputDir
+	if dir == "" {
+		dir = "."
+	}
+	file := filepath.Join(dir, name)
+	rel, err := filepath.Rel(dir, file)
+	if filepath.IsAbs(name) || err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		log.Panicf("Output %q would be outside the output directory %q", name, dir)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		log.Panicf("Couldn't create %s: %s", filepath.Dir(file), err)
+	}
+	return file
+}
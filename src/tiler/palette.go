@@ -0,0 +1,316 @@
+package tiler
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a palette chooses the colors tiles are drawn in. bonds which can join must
+// get the same color and pattern, so both may depend only on the bond and the
+// axis it's on.
+type palette interface {
+	bond(side Direction, bond Bond) color.RGBA
+	pattern(side Direction, bond Bond) int // index into bondPatterns
+	background(final bool) color.RGBA
+}
+
+// dash lengths in pixels, alternately drawn and skipped along a bond. the
+// first pattern is solid.
+var bondPatterns = [][]int{nil, {4, 2}, {1, 1}, {4, 1, 1, 1}}
+
+// the colorblind-safe categorical palette of Okabe and Ito, darkest first
+// since bond colors are also used for text on light backgrounds
+var okabeIto = []color.RGBA{
+	{0, 0, 0, 255},
+	{0, 114, 178, 255},
+	{213, 94, 0, 255},
+	{0, 158, 115, 255},
+	{204, 121, 167, 255},
+	{86, 180, 233, 255},
+	{230, 159, 0, 255},
+	{240, 228, 66, 255},
+}
+
+var grays = []color.RGBA{
+	{0, 0, 0, 255},
+	{80, 80, 80, 255},
+	{130, 130, 130, 255},
+	{170, 170, 170, 255},
+}
+
+// setupPalette picks the palette named by Palette, ranking bonds by how often
+// they appear in the tile pool, and layers Theme over it
+func (t *Tiler) setupPalette() {
+	switch t.Palette {
+	case "", "hash":
+		t.palette = hashPalette{t}
+	case "colorblind":
+		t.palette = &rankedPalette{
+			ranks:    t.bondRanks(),
+			colors:   okabeIto,
+			patterns: len(bondPatterns),
+			bg:       color.RGBA{255, 255, 255, 255},
+			final:    color.RGBA{255, 240, 200, 255},
+		}
+	case "grayscale":
+		t.palette = &rankedPalette{
+			ranks:    t.bondRanks(),
+			colors:   grays,
+			patterns: len(bondPatterns),
+			bg:       color.RGBA{255, 255, 255, 255},
+			final:    color.RGBA{220, 220, 220, 255},
+		}
+	default:
+		log.Panicf("Unknown palette %q, expected hash, colorblind or grayscale", t.Palette)
+	}
+	if t.Theme != "" {
+		t.palette = t.loadTheme(t.palette)
+	}
+}
+
+func (t *Tiler) bondColor(side Direction, bond Bond) color.RGBA {
+	if t.palette == nil {
+		t.palette = hashPalette{t}
+	}
+	return t.palette.bond(side, bond)
+}
+
+func (t *Tiler) bondPattern(side Direction, bond Bond) int {
+	if t.palette == nil {
+		t.palette = hashPalette{t}
+	}
+	return t.palette.pattern(side, bond)
+}
+
+func (t *Tiler) backgroundColor(final bool) color.RGBA {
+	if t.palette == nil {
+		t.palette = hashPalette{t}
+	}
+	return t.palette.background(final)
+}
+
+func bondKey(side Direction, bond Bond) string {
+	return fmt.Sprintf("%d%s%d", axis(side), bond.Label, bond.Strength)
+}
+
+// number every bond in the tile pool, the most common first
+func (t *Tiler) bondRanks() map[string]int {
+	counts := make(map[string]int)
+	for _, tile := range t.tiles {
+		for side, bond := range tile.Sides {
+			counts[bondKey(side, bond)]++
+		}
+	}
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	ranks := make(map[string]int)
+	for i, key := range keys {
+		ranks[key] = i
+	}
+	return ranks
+}
+
+// the original palette: colors derived from a hash of each label
+type hashPalette struct {
+	t *Tiler
+}
+
+func (p hashPalette) bond(side Direction, bond Bond) color.RGBA {
+	return p.t.getLabelColor(bondKey(side, bond), false)
+}
+
+func (p hashPalette) pattern(side Direction, bond Bond) int {
+	return 0
+}
+
+func (p hashPalette) background(final bool) color.RGBA {
+	// named as assemble.pl names them
+	if final {
+		return p.t.getLabelColor("background1", true)
+	}
+	return p.t.getLabelColor("background0", true)
+}
+
+// colors handed out in order of rank. once they run out, the next round goes
+// through the patterns, and then through darker shades.
+type rankedPalette struct {
+	ranks     map[string]int
+	colors    []color.RGBA
+	patterns  int
+	bg, final color.RGBA
+}
+
+func (p *rankedPalette) rank(side Direction, bond Bond) int {
+	key := bondKey(side, bond)
+	r, exists := p.ranks[key]
+	if !exists {
+		r = len(p.ranks)
+		p.ranks[key] = r
+	}
+	return r
+}
+
+func (p *rankedPalette) bond(side Direction, bond Bond) color.RGBA {
+	r := p.rank(side, bond)
+	c := p.colors[r%len(p.colors)]
+	shade := r / (len(p.colors) * p.patterns)
+	for i := 0; i < shade; i++ {
+		c = color.RGBA{c.R * 2 / 3, c.G * 2 / 3, c.B * 2 / 3, 255}
+	}
+	return c
+}
+
+func (p *rankedPalette) pattern(side Direction, bond Bond) int {
+	return p.rank(side, bond) / len(p.colors) % p.patterns
+}
+
+func (p *rankedPalette) background(final bool) color.RGBA {
+	if final {
+		return p.final
+	}
+	return p.bg
+}
+
+// a theme file overriding another palette
+type themePalette struct {
+	palette
+	bg, final *color.RGBA
+	labels    map[string]color.RGBA // bonds with exactly this label
+	states    map[string]color.RGBA // state bonds, and head bonds in the state
+}
+
+var (
+	themeBackgroundRx = regexp.MustCompile("^(BACKGROUND|FINAL)\\s+([0-9a-fA-F]{6})$")
+	themeLabelRx      = regexp.MustCompile("^(LABEL|STATE)\\s+(.+?)\\s+([0-9a-fA-F]{6})$")
+)
+
+// load Theme, a file of lines like those of a machine:
+//
+//	BACKGROUND eeeeee
+//	FINAL ffeecc
+//	LABEL <bond label> 0072b2
+//	STATE <state> d55e00
+//
+// colors are six hex digits without a leading #, which starts a comment
+func (t *Tiler) loadTheme(base palette) palette {
+	f, err := os.Open(t.Theme)
+	if err != nil {
+		log.Panicf("Couldn't open %s: %s", t.Theme, err)
+	}
+	defer f.Close()
+
+	p := themePalette{
+		palette: base,
+		labels:  make(map[string]color.RGBA),
+		states:  make(map[string]color.RGBA),
+	}
+	log.Printf("Loading theme from %q...", t.Theme)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := parserCommentRx.ReplaceAllString(scanner.Text(), "")
+		line = strings.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if c := themeBackgroundRx.FindStringSubmatch(line); c != nil {
+			col := hexColor(c[2])
+			if c[1] == "BACKGROUND" {
+				p.bg = &col
+			} else {
+				p.final = &col
+			}
+		} else if c := themeLabelRx.FindStringSubmatch(line); c != nil {
+			if c[1] == "LABEL" {
+				p.labels[c[2]] = hexColor(c[3])
+			} else {
+				p.states[c[2]] = hexColor(c[3])
+			}
+		} else {
+			log.Panicf("Couldn't parse line %d of %s: %q", n, t.Theme, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Panicf("Couldn't read %s: %s", t.Theme, err)
+	}
+	return &p
+}
+
+// the regexps only let through six hex digits
+func hexColor(s string) color.RGBA {
+	v, _ := strconv.ParseUint(s, 16, 32)
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}
+}
+
+func (p *themePalette) bond(side Direction, bond Bond) color.RGBA {
+	if c, exists := p.labels[bond.Label]; exists {
+		return c
+	}
+	if axis(side) == axis(Left) {
+		if c, exists := p.states[bond.Label]; exists {
+			return c
+		}
+	} else if bond.Strength == 2 {
+		// head bonds are labeled "state symbol"
+		if n := strings.LastIndex(bond.Label, " "); n >= 0 {
+			if c, exists := p.states[bond.Label[:n]]; exists {
+				return c
+			}
+		}
+	}
+	return p.palette.bond(side, bond)
+}
+
+func (p *themePalette) background(final bool) color.RGBA {
+	if final && p.final != nil {
+		return *p.final
+	}
+	if !final && p.bg != nil {
+		return *p.bg
+	}
+	return p.palette.background(final)
+}
+
+// split the bars of a bond into the dashes of its pattern, running along the
+// side it's on
+func patternRects(rects []image.Rectangle, side Direction, pattern int) []image.Rectangle {
+	dashes := bondPatterns[pattern]
+	if len(dashes) == 0 {
+		return rects
+	}
+	var out []image.Rectangle
+	for _, r := range rects {
+		along := axis(side) != axis(Left) // up and down bars run along x
+		start, end := r.Min.Y, r.Max.Y
+		if along {
+			start, end = r.Min.X, r.Max.X
+		}
+		for pos, i := start, 0; pos < end; i++ {
+			length := dashes[i%len(dashes)]
+			if i%2 == 0 {
+				if along {
+					out = append(out, image.Rect(pos, r.Min.Y, pos+length, r.Max.Y).Intersect(r))
+				} else {
+					out = append(out, image.Rect(r.Min.X, pos, r.Max.X, pos+length).Intersect(r))
+				}
+			}
+			pos += length
+		}
+	}
+	return out
+}
@@ -2,6 +2,8 @@ package tiler
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
@@ -16,6 +18,7 @@ type Machine struct {
 	Transitions     []Transition
 	InitialState    string
 	InitialLocation int
+	States          int // as declared by STATES, or 0
 }
 
 type Transition struct {
@@ -31,6 +34,7 @@ var (
 	parserSymbolRx     = regexp.MustCompile("^SYMBOL\\s+(\\S+)")
 	parserStartRx      = regexp.MustCompile("^START\\s+(\\S+)")
 	parserOffsetRx     = regexp.MustCompile("^OFFSET\\s+(\\d+)")
+	parserStatesRx     = regexp.MustCompile("^STATES\\s+(\\d+)")
 	parserTransitionRx = regexp.MustCompile("^TRANSITION\\s+(\\S+)\\s+(\\S+)\\s+(\\S+)\\s+([HhLlRr])\\s+(\\S+)(?:\\s+(\\S+))?")
 )
 
@@ -50,6 +54,13 @@ func (t *Tiler) ParseMachine() *Machine {
 		name = name[:n]
 	}
 
+	log.Printf("Parsing machine from %q...", t.MachineFile)
+	return t.parseMachine(f, name)
+}
+
+// parseMachine reads a machine definition, naming the machine name unless it
+// has a NAME statement
+func (t *Tiler) parseMachine(r io.Reader, name string) *Machine {
 	m := Machine{
 		Name:            name,
 		Symbols:         make([]rune, 0),
@@ -58,8 +69,7 @@ func (t *Tiler) ParseMachine() *Machine {
 		InitialLocation: 0,
 	}
 
-	log.Printf("Parsing machine from %q...", t.MachineFile)
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
 		line = parserWhitespaceRx.ReplaceAllString(line, "")
@@ -76,6 +86,8 @@ func (t *Tiler) ParseMachine() *Machine {
 			m.InitialState = c[1]
 		} else if c := parserOffsetRx.FindStringSubmatch(line); c != nil {
 			m.InitialLocation, _ = strconv.Atoi(c[1]) // can't err because \d+
+		} else if c := parserStatesRx.FindStringSubmatch(line); c != nil {
+			m.States, _ = strconv.Atoi(c[1])
 		} else if c := parserTransitionRx.FindStringSubmatch(line); c != nil {
 			/*
 				more clearly:
@@ -108,6 +120,16 @@ func (t *Tiler) ParseMachine() *Machine {
 	return &m
 }
 
+// String formats a transition the way its TRANSITION statement does
+func (trans *Transition) String() string {
+	s := fmt.Sprintf("%s %s %s %s %s", trans.OldState, trans.ReadSymbol, trans.WriteSymbol,
+		directionToLetter(trans.Move), trans.NewState)
+	if trans.Output != "" {
+		s += " " + trans.Output
+	}
+	return s
+}
+
 func letterToDirection(letter string) Direction {
 	switch strings.ToLower(letter) {
 	case "l":
@@ -120,3 +142,16 @@ func letterToDirection(letter string) Direction {
 	log.Panicf("parserTransitionRx should only match /[HhLlRr]/ but got %q", letter)
 	return 0
 }
+
+func directionToLetter(d Direction) string {
+	switch d {
+	case Left:
+		return "l"
+	case Right:
+		return "r"
+	case Halt:
+		return "h"
+	}
+	log.Panicf("Transitions can only move left, right or halt, not %v", d)
+	return ""
+}
@@ -0,0 +1,231 @@
+package tiler
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// size of the square images making up each level of a pyramid
+const pyramidTileSize = 256
+
+// below this many pixels across, tiles are drawn as solid blocks of color
+// rather than shrunk
+const pyramidMinTilePixels = 4
+
+// writePyramid renders an already rotated assembly as a Deep Zoom image: a
+// <base>.dzi descriptor and a <base>_files directory holding one directory of
+// images per level, halving in size down to a single pixel. a <base>.html
+// viewer is written alongside for browsing it from the local filesystem.
+func (t *Tiler) writePyramid(base string, sizeX, sizeY int, assembly Assembly) {
+	width, height := t.canvasSize(sizeX, sizeY)
+
+	maxLevel := 0
+	for 1<<uint(maxLevel) < width || 1<<uint(maxLevel) < height {
+		maxLevel++
+	}
+
+	dir := base + "_files"
+	for level := maxLevel; level >= 0; level-- {
+		scale := 1 << uint(maxLevel-level) // canvas pixels per level pixel
+		levelW := (width + scale - 1) / scale
+		levelH := (height + scale - 1) / scale
+		log.Printf("Rendering pyramid level %d (%dx%d)...", level, levelW, levelH)
+
+		levelDir := filepath.Join(dir, fmt.Sprint(level))
+		if err := os.MkdirAll(levelDir, 0755); err != nil {
+			log.Panicf("Couldn't create %s: %s", levelDir, err)
+		}
+		for row := 0; row*pyramidTileSize < levelH; row++ {
+			for col := 0; col*pyramidTileSize < levelW; col++ {
+				r := image.Rect(col*pyramidTileSize, row*pyramidTileSize,
+					(col+1)*pyramidTileSize, (row+1)*pyramidTileSize).
+					Intersect(image.Rect(0, 0, levelW, levelH))
+				var im *image.RGBA
+				if t.TileWidth/scale >= pyramidMinTilePixels && t.TileHeight/scale >= pyramidMinTilePixels {
+					canvas := image.Rect(r.Min.X*scale, r.Min.Y*scale, r.Max.X*scale, r.Max.Y*scale)
+					im = shrink(t.renderRegion(sizeX, sizeY, assembly, canvas), scale)
+				} else {
+					im = t.renderColors(sizeX, sizeY, assembly, r, scale)
+				}
+				t.saveImage(filepath.Join(levelDir, fmt.Sprintf("%d_%d.png", col, row)), im)
+			}
+		}
+	}
+
+	dzi, err := os.Create(base + ".dzi")
+	if err != nil {
+		log.Panicf("Couldn't create %s.dzi: %s", base, err)
+	}
+	defer dzi.Close()
+	fmt.Fprintf(dzi, `<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" Format="png" Overlap="0" TileSize="%d">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`, pyramidTileSize, width, height)
+
+	viewer, err := os.Create(base + ".html")
+	if err != nil {
+		log.Panicf("Couldn't create %s.html: %s", base, err)
+	}
+	defer viewer.Close()
+	err = pyramidViewer.Execute(viewer, map[string]interface{}{
+		"Title":    base,
+		"Dir":      filepath.Base(dir),
+		"Width":    width,
+		"Height":   height,
+		"TileSize": pyramidTileSize,
+		"MaxLevel": maxLevel,
+	})
+	if err != nil {
+		log.Panicf("Couldn't write %s.html: %s", base, err)
+	}
+}
+
+// shrink an image by an integer factor, averaging each block of pixels
+func shrink(src *image.RGBA, scale int) *image.RGBA {
+	if scale == 1 {
+		return src
+	}
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(b.Min.X/scale, b.Min.Y/scale,
+		(b.Max.X+scale-1)/scale, (b.Max.Y+scale-1)/scale))
+	db := dst.Bounds()
+	for y := db.Min.Y; y < db.Max.Y; y++ {
+		for x := db.Min.X; x < db.Max.X; x++ {
+			var sum [4]int
+			n := 0
+			for sy := y * scale; sy < (y+1)*scale && sy < b.Max.Y; sy++ {
+				for sx := x * scale; sx < (x+1)*scale && sx < b.Max.X; sx++ {
+					p := src.PixOffset(sx, sy)
+					for c := range sum {
+						sum[c] += int(src.Pix[p+c])
+					}
+					n++
+				}
+			}
+			p := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[p+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// draw the region r of a level where each canvas pixel is scale pixels across,
+// coloring each pixel by the tile under its center
+func (t *Tiler) renderColors(sizeX, sizeY int, assembly Assembly, r image.Rectangle, scale int) *image.RGBA {
+	im := image.NewRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := sizeY - 1 - (y*scale+scale/2)/(t.TileHeight-1)
+		if i < 0 {
+			i = 0
+		}
+		for x := r.Min.X; x < r.Max.X; x++ {
+			j := (x*scale + scale/2) / (t.TileWidth - 1)
+			if j >= sizeX {
+				j = sizeX - 1
+			}
+			if tile := assembly[i][j]; tile != nil {
+				im.SetRGBA(x, y, t.tileColor(tile))
+			}
+		}
+	}
+	return im
+}
+
+// a self-contained page which pans and zooms over a pyramid. it only loads
+// images by relative path, so it works straight from the filesystem.
+var pyramidViewer = template.Must(template.New("viewer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+html, body { margin: 0; height: 100%; overflow: hidden; background: #444; font: 12px sans-serif; }
+#view { position: absolute; top: 0; left: 0; right: 0; bottom: 0; cursor: move; }
+#view img { position: absolute; image-rendering: pixelated; }
+#help { position: absolute; bottom: 4px; left: 4px; color: #ccc; }
+</style>
+</head>
+<body>
+<div id="view"></div>
+<div id="help">drag to pan, scroll to zoom, double click to fit</div>
+<script>
+var dir = {{.Dir}}, width = {{.Width}}, height = {{.Height}};
+var tileSize = {{.TileSize}}, maxLevel = {{.MaxLevel}};
+var view = document.getElementById("view");
+var tiles = {};
+var zoom, x0, y0; // screen pixels per canvas pixel, and canvas position of the view's corner
+
+function fit() {
+	zoom = Math.min(view.clientWidth / width, view.clientHeight / height);
+	x0 = (width - view.clientWidth / zoom) / 2;
+	y0 = 0;
+	draw();
+}
+
+function draw() {
+	var level = Math.max(0, Math.min(maxLevel, maxLevel + Math.ceil(Math.log(zoom) / Math.LN2)));
+	var scale = Math.pow(2, maxLevel - level); // canvas pixels per level pixel
+	var levelW = Math.ceil(width / scale), levelH = Math.ceil(height / scale);
+	var span = tileSize * scale; // canvas pixels per image
+	var c0 = Math.max(0, Math.floor(x0 / span));
+	var r0 = Math.max(0, Math.floor(y0 / span));
+	var c1 = Math.min(Math.ceil(levelW / tileSize) - 1, Math.floor((x0 + view.clientWidth / zoom) / span));
+	var r1 = Math.min(Math.ceil(levelH / tileSize) - 1, Math.floor((y0 + view.clientHeight / zoom) / span));
+
+	var wanted = {};
+	for (var r = r0; r <= r1; r++) {
+		for (var c = c0; c <= c1; c++) {
+			var src = dir + "/" + level + "/" + c + "_" + r + ".png";
+			var img = tiles[src];
+			if (!img) {
+				img = tiles[src] = document.createElement("img");
+				img.src = src;
+				view.appendChild(img);
+			}
+			wanted[src] = true;
+			img.style.left = Math.round((c * span - x0) * zoom) + "px";
+			img.style.top = Math.round((r * span - y0) * zoom) + "px";
+			img.style.width = Math.ceil(Math.min(tileSize, levelW - c * tileSize) * scale * zoom) + "px";
+			img.style.height = Math.ceil(Math.min(tileSize, levelH - r * tileSize) * scale * zoom) + "px";
+		}
+	}
+	for (var src in tiles) {
+		if (!wanted[src]) {
+			view.removeChild(tiles[src]);
+			delete tiles[src];
+		}
+	}
+}
+
+var dragging = null;
+view.onmousedown = function(e) { dragging = [e.clientX, e.clientY]; e.preventDefault(); };
+window.onmouseup = function() { dragging = null; };
+window.onmousemove = function(e) {
+	if (!dragging) return;
+	x0 -= (e.clientX - dragging[0]) / zoom;
+	y0 -= (e.clientY - dragging[1]) / zoom;
+	dragging = [e.clientX, e.clientY];
+	draw();
+};
+view.onwheel = function(e) {
+	var factor = e.deltaY < 0 ? 1.25 : 0.8;
+	x0 += e.clientX / zoom * (1 - 1 / factor);
+	y0 += e.clientY / zoom * (1 - 1 / factor);
+	zoom *= factor;
+	draw();
+	e.preventDefault();
+};
+view.ondblclick = fit;
+window.onresize = draw;
+fit();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,301 @@
+package tiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+)
+
+// what the report shows for each row of the assembly
+type reportStep struct {
+	Tape       []string `json:"tape"`
+	Head       int      `json:"head"`
+	State      string   `json:"state"`
+	Halted     bool     `json:"halted"`
+	Output     string   `json:"output"`
+	Transition string   `json:"transition"`
+	Band       [4]int   `json:"band"` // x, y, width and height of the row in the assembly image
+}
+
+// what the report shows when a tile is clicked
+type reportTile struct {
+	Name  string          `json:"name"`
+	Final bool            `json:"final"`
+	Bonds map[string]Bond `json:"bonds"`
+}
+
+// writeReport saves a single self-contained html page for an unrotated
+// assembly: the assembly drawn as svg, a slider stepping through its rows with
+// the tape, state and transition applied at each, and the state diagram.
+func (t *Tiler) writeReport(input string, assembly Assembly) {
+	sizeX, sizeY := len(assembly[0]), len(assembly)
+	rotX, rotY := sizeX, sizeY
+	if t.Rotation%2 == 1 {
+		rotX, rotY = sizeY, sizeX
+	}
+
+	// number each distinct tile so that it's only drawn once
+	var tiles []reportTile
+	var defs bytes.Buffer
+	ids := make(map[*Tile]int)
+	for _, row := range assembly {
+		for _, tile := range row {
+			if _, exists := ids[tile]; exists || tile == nil {
+				continue
+			}
+			ids[tile] = len(tiles)
+			bonds := make(map[string]Bond)
+			for side, bond := range tile.Sides {
+				bonds[side.String()] = bond
+			}
+			tiles = append(tiles, reportTile{tile.Name, tile.Final, bonds})
+			t.writeTileSVG(&defs, fmt.Sprintf("tile-%d", ids[tile]), tile)
+		}
+	}
+
+	var svg bytes.Buffer
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" id="assembly" width="%d" height="%d" font-family="sans-serif" font-size="%.1f">`+"\n",
+		t.TileWidth*rotX-rotX+1, t.TileHeight*rotY-rotY+1, t.FontSize)
+	fmt.Fprintf(&svg, "<defs>\n%s</defs>\n", defs.String())
+	var steps []reportStep
+	var prev *Config
+	for j, row := range assembly {
+		minX, minY, maxX, maxY := math.MaxInt32, math.MaxInt32, 0, 0
+		for i, tile := range row {
+			rj, ri := t.rotatedPosition(j, i, sizeX, sizeY)
+			x := ri * (t.TileWidth - 1)
+			y := (rotY - 1 - rj) * (t.TileHeight - 1)
+			if x < minX {
+				minX = x
+			}
+			if y < minY {
+				minY = y
+			}
+			if x > maxX {
+				maxX = x
+			}
+			if y > maxY {
+				maxY = y
+			}
+			if tile != nil {
+				fmt.Fprintf(&svg, `<use href="#tile-%d" x="%d" y="%d" data-t="%d" data-row="%d"/>`+"\n",
+					ids[tile], x, y, ids[tile], j)
+			}
+		}
+
+		conf := tileRowConfig(row)
+		step := reportStep{
+			Head:   conf.Head,
+			State:  conf.State,
+			Halted: conf.Halted,
+			Output: conf.Output,
+			Band:   [4]int{minX, minY, maxX - minX + t.TileWidth, maxY - minY + t.TileHeight},
+		}
+		for _, r := range conf.Tape {
+			step.Tape = append(step.Tape, string(r))
+		}
+		if prev != nil && prev.Head >= 0 {
+			if trans := t.findTransition(prev.State, string(prev.Tape[prev.Head])); trans != nil {
+				step.Transition = trans.String()
+				if conf.Halted {
+					step.State = trans.NewState
+				}
+			}
+		}
+		steps = append(steps, step)
+		prev = conf
+	}
+	fmt.Fprintln(&svg, `<rect id="cursor" fill="none" stroke="red" stroke-width="3"/>`)
+	fmt.Fprintln(&svg, "</svg>")
+
+	var diagram bytes.Buffer
+	t.StateGraph(true).WriteSVG(&diagram)
+
+	stepsJSON, err := json.Marshal(steps)
+	if err != nil {
+		log.Panicf("Couldn't encode steps: %s", err)
+	}
+	tilesJSON, err := json.Marshal(tiles)
+	if err != nil {
+		log.Panicf("Couldn't encode tiles: %s", err)
+	}
+
+	last := steps[len(steps)-1]
+	file := t.outputFile(input, "-report", "html")
+	log.Printf("Saving report %s...", file)
+	w, err := os.Create(file)
+	if err != nil {
+		log.Panicf("Couldn't create %s: %s", file, err)
+	}
+	defer w.Close()
+	err = reportTemplate.Execute(w, map[string]interface{}{
+		"Name":     t.Name,
+		"Input":    input,
+		"Steps":    len(steps) - 1,
+		"Halted":   last.Halted,
+		"Output":   last.Output,
+		"StepData": template.JS(stepsJSON),
+		"TileData": template.JS(tilesJSON),
+		"Assembly": template.HTML(svg.String()),
+		"Diagram":  template.HTML(diagram.String()),
+	})
+	if err != nil {
+		log.Panicf("Couldn't write %s: %s", file, err)
+	}
+}
+
+func (t *Tiler) findTransition(state, symbol string) *Transition {
+	for i := range t.Transitions {
+		if trans := &t.Transitions[i]; trans.OldState == state && trans.ReadSymbol == symbol {
+			return trans
+		}
+	}
+	return nil
+}
+
+// draw a tile as an svg group the same way generateImage draws it as an image
+func (t *Tiler) writeTileSVG(w *bytes.Buffer, id string, tile *Tile) {
+	fmt.Fprintf(w, `<g id="%s">`, id)
+	bg := t.backgroundColor(tile.Final)
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="%s"/>`, t.TileWidth, t.TileHeight, svgColor(bg))
+
+	for _, side := range []Direction{Up, Down, Left, Right} {
+		bond := tile.Sides[side]
+		col := svgColor(t.bondColor(side, bond))
+		rotSide := t.rotatedDirection(side)
+		for _, r := range patternRects(t.bondRects(rotSide, bond.Strength), rotSide, t.bondPattern(side, bond)) {
+			r = r.Intersect(image.Rect(0, 0, t.TileWidth, t.TileHeight))
+			fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`,
+				r.Min.X, r.Min.Y, r.Dx(), r.Dy(), col)
+		}
+		if bond.Label == "" {
+			continue
+		}
+
+		// placed as drawString places it
+		x, y, size, anchor := t.labelAnchor(bond.Label, rotSide, bond.Strength)
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" font-size="%.1f" text-anchor="%s" fill="%s">%s</text>`,
+			x, y, size, anchor, col, html.EscapeString(bond.Label))
+	}
+	fmt.Fprintln(w, "</g>")
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} {{.Input}}</title>
+<style>
+body { margin: 0; font: 13px sans-serif; display: flex; height: 100vh; }
+#left { flex: 1; overflow: auto; background: #eee; }
+#right { width: 420px; overflow: auto; padding: 8px 12px; border-left: 1px solid #aaa; }
+h1 { font-size: 16px; margin: 4px 0; }
+#step { width: 100%; }
+#tape { font: 16px monospace; white-space: pre; overflow-x: auto; padding: 4px 0; }
+#tape span { padding: 0 2px; }
+#tape .head { background: #fc0; font-weight: bold; }
+#assembly use { cursor: pointer; }
+.diagram g.current circle { stroke: orange; stroke-width: 5; }
+table { border-collapse: collapse; }
+td, th { padding: 1px 6px; text-align: left; }
+.label { font-family: monospace; }
+</style>
+</head>
+<body>
+<div id="left">{{.Assembly}}</div>
+<div id="right">
+<h1>{{.Name}}: {{.Input}}</h1>
+<p>{{.Steps}} steps{{if .Halted}}, halted{{if .Output}} with output <b>{{.Output}}</b>{{end}}{{else}}, did not halt{{end}}</p>
+<input type="range" id="step" min="0" value="0">
+<p>step <b id="stepnum"></b>: state <b id="state"></b>, head at <b id="head"></b></p>
+<div id="tape"></div>
+<p>transition: <span class="label" id="transition"></span></p>
+<h1>Tile</h1>
+<div id="tile">click a tile to inspect it</div>
+<h1>States</h1>
+{{.Diagram}}
+</div>
+<script>
+var steps = {{.StepData}};
+var tiles = {{.TileData}};
+var slider = document.getElementById("step");
+var cursor = document.getElementById("cursor");
+slider.max = steps.length - 1;
+
+function text(id, s) {
+	document.getElementById(id).textContent = s;
+}
+
+function show(k) {
+	var s = steps[k];
+	slider.value = k;
+	text("stepnum", k);
+	text("state", s.halted ? s.state + " (halted" + (s.output ? ": " + s.output : "") + ")" : s.state);
+	text("head", s.head);
+	text("transition", s.transition || (k == 0 ? "(initial tape)" : "(none)"));
+
+	var tape = document.getElementById("tape");
+	tape.innerHTML = "";
+	for (var i = 0; i < s.tape.length; i++) {
+		var span = document.createElement("span");
+		span.textContent = s.tape[i];
+		if (i == s.head) span.className = "head";
+		tape.appendChild(span);
+	}
+
+	cursor.setAttribute("x", s.band[0]);
+	cursor.setAttribute("y", s.band[1]);
+	cursor.setAttribute("width", s.band[2]);
+	cursor.setAttribute("height", s.band[3]);
+
+	var nodes = document.querySelectorAll(".diagram g[data-state]");
+	for (var i = 0; i < nodes.length; i++) {
+		nodes[i].setAttribute("class", nodes[i].getAttribute("data-state") == s.state ? "current" : "");
+	}
+}
+
+function showTile(n) {
+	var tile = tiles[n];
+	var rows = "<tr><th>name</th><td class=label></td></tr>";
+	var sides = ["up", "down", "left", "right"];
+	for (var i = 0; i < sides.length; i++) {
+		rows += "<tr><th>" + sides[i] + "</th><td class=label></td></tr>";
+	}
+	var div = document.getElementById("tile");
+	div.innerHTML = "<table>" + rows + "</table>" + (tile.final ? "<p>final tile</p>" : "");
+	var cells = div.querySelectorAll("td");
+	cells[0].textContent = tile.name;
+	for (var i = 0; i < sides.length; i++) {
+		var bond = tile.bonds[sides[i]];
+		cells[i + 1].textContent = JSON.stringify(bond.Label) + (bond.Strength > 1 ? " (double)" : "");
+	}
+}
+
+slider.oninput = function() { show(+slider.value); };
+document.getElementById("assembly").onclick = function(e) {
+	var use = e.target.closest("[data-t]");
+	if (!use) return;
+	showTile(+use.getAttribute("data-t"));
+	show(+use.getAttribute("data-row"));
+};
+document.onkeydown = function(e) {
+	var k = +slider.value;
+	if (e.key == "ArrowRight" && k < steps.length - 1) show(k + 1);
+	if (e.key == "ArrowLeft" && k > 0) show(k - 1);
+};
+show(0);
+</script>
+</body>
+</html>
+`))
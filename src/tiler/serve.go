@@ -0,0 +1,376 @@
+package tiler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server renders machines posted to it over HTTP, drawing them as Options
+// says. MaxDepth caps how many transitions a request may ask for, and is what
+// it gets if it doesn't ask; MaxPixels caps the images it draws, and
+// MaxTraceCells the traces it returns.
+//
+//	POST /assemble  the assembly image, with the outcome in X-Turing-* headers
+//	POST /simulate  the simulation trace as JSON
+//	POST /diagram   the state diagram as svg (the default), dot or png
+//
+// the body is either a JSON object with the machine definition, input,
+// max_depth, format and concise, or the definition alone with the rest in the
+// query string.
+type Server struct {
+	Options
+	MaxRequestBytes int64         // largest body accepted
+	Timeout         time.Duration // longest a request may take; 0 for no limit
+	CacheSize       int           // how many machines' tiles to keep
+	MaxPixels       int           // largest assembly or diagram image drawn; 0 for no limit
+	MaxTraceCells   int           // most tape cells of all steps in a trace; 0 for no limit
+
+	mu     sync.Mutex
+	cache  map[string]*servedMachine
+	recent []string // cache keys, least recently used first
+}
+
+// a posted machine with its tiles made. tilers can't be shared between
+// goroutines, so holding lock gives a request the tiler to itself.
+type servedMachine struct {
+	lock  chan struct{}
+	tiler *Tiler // nil until made, or if the definition is bad
+}
+
+type serveRequest struct {
+	Machine  string `json:"machine"`
+	Input    string `json:"input"`
+	MaxDepth int    `json:"max_depth"`
+	Format   string `json:"format"`
+	Concise  *bool  `json:"concise"`
+}
+
+// a failed request, with the status to answer it with
+type serveError struct {
+	status  int
+	message string
+}
+
+func (e *serveError) Error() string {
+	return e.message
+}
+
+func serveErrorf(status int, format string, args ...interface{}) *serveError {
+	return &serveError{status, fmt.Sprintf(format, args...)}
+}
+
+// content types by format
+var serveTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"gif":  "image/gif",
+	"bmp":  "image/bmp",
+	"tiff": "image/tiff",
+	"tif":  "image/tiff",
+	"svg":  "image/svg+xml",
+	"dot":  "text/vnd.graphviz; charset=utf-8",
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	handlers := map[string]func(context.Context, *Tiler, *serveRequest, http.ResponseWriter) error{
+		"/assemble": s.serveAssembly,
+		"/simulate": s.serveSimulation,
+		"/diagram":  s.serveDiagram,
+	}
+	handler, exists := handlers[r.URL.Path]
+	var err error
+	switch {
+	case !exists:
+		err = serveErrorf(http.StatusNotFound, "no such endpoint; POST to /assemble, /simulate or /diagram")
+	case r.Method != "POST":
+		w.Header().Set("Allow", "POST")
+		err = serveErrorf(http.StatusMethodNotAllowed, "%s needs a POST", r.URL.Path)
+	default:
+		err = s.serve(w, r, handler)
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+		if e, ok := err.(*serveError); ok {
+			status = e.status
+		}
+		http.Error(w, err.Error(), status)
+	}
+	log.Printf("%s %s: %d %s in %s", r.Method, r.URL.Path, status, http.StatusText(status), time.Since(start))
+}
+
+// serve reads a request, then gives handler the machine's tiler once no other
+// request is using it
+func (s *Server) serve(w http.ResponseWriter, r *http.Request,
+	handler func(context.Context, *Tiler, *serveRequest, http.ResponseWriter) error) error {
+	ctx := r.Context()
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	req, err := s.readRequest(w, r)
+	if err != nil {
+		return err
+	}
+	if req.MaxDepth <= 0 || (s.MaxDepth > 0 && req.MaxDepth > s.MaxDepth) {
+		req.MaxDepth = s.MaxDepth
+	}
+
+	m := s.machine(req.Machine)
+	select {
+	case m.lock <- struct{}{}:
+		defer func() { <-m.lock }()
+	case <-ctx.Done():
+		return s.contextError(ctx)
+	}
+	if m.tiler == nil {
+		if failure := catchPanic(func() { m.tiler = s.newTiler(req.Machine) }); failure != "" {
+			return serveErrorf(http.StatusBadRequest, "bad machine: %s", failure)
+		}
+	}
+	m.tiler.MaxDepth = req.MaxDepth
+
+	if failure := catchPanic(func() { err = handler(ctx, m.tiler, req, w) }); failure != "" {
+		return fmt.Errorf("%s", failure)
+	}
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return s.contextError(ctx)
+	}
+	return err
+}
+
+func (s *Server) contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return serveErrorf(http.StatusServiceUnavailable, "gave up after %s", s.Timeout)
+	}
+	return serveErrorf(http.StatusServiceUnavailable, "%s", ctx.Err())
+}
+
+func (s *Server) readRequest(w http.ResponseWriter, r *http.Request) (*serveRequest, error) {
+	body := r.Body
+	if s.MaxRequestBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.MaxRequestBytes)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		if errors.As(err, new(*http.MaxBytesError)) {
+			return nil, serveErrorf(http.StatusRequestEntityTooLarge, "requests are limited to %d bytes", s.MaxRequestBytes)
+		}
+		return nil, serveErrorf(http.StatusBadRequest, "couldn't read the request: %s", err)
+	}
+
+	var req serveRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, serveErrorf(http.StatusBadRequest, "couldn't parse the request: %s", err)
+		}
+	} else {
+		query := r.URL.Query()
+		req.Machine = string(data)
+		req.Input = query.Get("input")
+		req.Format = query.Get("format")
+		if v := query.Get("max_depth"); v != "" {
+			if req.MaxDepth, err = strconv.Atoi(v); err != nil {
+				return nil, serveErrorf(http.StatusBadRequest, "max_depth should be a number, not %q", v)
+			}
+		}
+		if v := query.Get("concise"); v != "" {
+			concise, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, serveErrorf(http.StatusBadRequest, "concise should be true or false, not %q", v)
+			}
+			req.Concise = &concise
+		}
+	}
+	if strings.TrimSpace(req.Machine) == "" {
+		return nil, serveErrorf(http.StatusBadRequest, "no machine definition given")
+	}
+	return &req, nil
+}
+
+// machine finds a posted machine in the cache by a hash of its definition,
+// adding it if it isn't there and dropping the least recently used if the
+// cache is full
+func (s *Server) machine(definition string) *servedMachine {
+	sum := sha256.Sum256([]byte(definition))
+	key := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cache == nil {
+		s.cache = make(map[string]*servedMachine)
+	}
+	for i, k := range s.recent {
+		if k == key {
+			s.recent = append(s.recent[:i], s.recent[i+1:]...)
+			break
+		}
+	}
+	s.recent = append(s.recent, key)
+	m, exists := s.cache[key]
+	if !exists {
+		m = &servedMachine{lock: make(chan struct{}, 1)}
+		s.cache[key] = m
+	}
+	for len(s.recent) > s.CacheSize && len(s.recent) > 1 {
+		delete(s.cache, s.recent[0])
+		s.recent = s.recent[1:]
+	}
+	return m
+}
+
+// newTiler makes the tiles of a posted machine, named "machine" unless it
+// says otherwise
+func (s *Server) newTiler(definition string) *Tiler {
+	t := Tiler{Options: s.Options}
+	t.setupDrawer()
+	t.Machine = t.parseMachine(strings.NewReader(definition), "machine")
+	t.GenerateTiles()
+	return &t
+}
+
+func (s *Server) serveAssembly(ctx context.Context, t *Tiler, req *serveRequest, w http.ResponseWriter) error {
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = t.imageFormat()
+	}
+	encode, exists := imageEncoders[format]
+	if !exists {
+		return serveErrorf(http.StatusBadRequest, "unknown image format %q, expected png, jpeg, gif, bmp or tiff", req.Format)
+	}
+
+	im, outcome, err := t.renderContext(ctx, req.Input, s.MaxPixels)
+	if err != nil {
+		return err
+	}
+	if im == nil {
+		return serveErrorf(http.StatusBadRequest, "%s", outcome.Failure)
+	}
+	var buf bytes.Buffer
+	meta := t.runMeta(req.Input, outcome.Steps, &Config{Halted: outcome.Halted, Output: outcome.Output})
+	if err := encode(ctx, &buf, im, meta, &t.Options); err != nil {
+		return err
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", serveTypes[format])
+	h.Set("X-Turing-Steps", strconv.Itoa(outcome.Steps))
+	h.Set("X-Turing-Halted", strconv.FormatBool(outcome.Halted))
+	if outcome.Halted {
+		h.Set("X-Turing-Output", outcome.Output)
+	}
+	if outcome.Failure != "" {
+		h.Set("X-Turing-Failure", outcome.Failure)
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// a simulation as /simulate returns it
+type serveTrace struct {
+	Machine string      `json:"machine"`
+	Input   string      `json:"input"`
+	Steps   int         `json:"steps"`
+	Halted  bool        `json:"halted"`
+	Output  string      `json:"output,omitempty"`
+	Failure string      `json:"failure,omitempty"`
+	Trace   []serveStep `json:"trace"`
+}
+
+// the configuration after a step, with Head counting the left boundary cell
+type serveStep struct {
+	State string `json:"state"`
+	Head  int    `json:"head"`
+	Tape  string `json:"tape"`
+}
+
+func (s *Server) serveSimulation(ctx context.Context, t *Tiler, req *serveRequest, w http.ResponseWriter) error {
+	if !t.validInput(req.Input) {
+		return serveErrorf(http.StatusBadRequest, "invalid symbol in input")
+	}
+	// every step's tape is kept until the trace is encoded, so a long run on a
+	// long tape is refused once it reaches MaxTraceCells
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var trace []serveStep
+	var last Config
+	cells, tooLong := 0, false
+	completed, err := t.simulateRowsContext(ctx, req.Input, func(conf *Config) {
+		if cells += len(conf.Tape); s.MaxTraceCells > 0 && cells > s.MaxTraceCells {
+			tooLong = true
+			cancel()
+		}
+		if tooLong {
+			return
+		}
+		trace = append(trace, serveStep{conf.State, conf.Head, string(conf.Tape)})
+		last = *conf
+	})
+	if tooLong {
+		return serveErrorf(http.StatusBadRequest, "the trace would be more than %d cells; ask for a smaller max_depth", s.MaxTraceCells)
+	}
+	if err != nil {
+		return err
+	}
+	outcome := t.outcome(req.Input, len(trace)-1, &last, completed)
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(serveTrace{
+		Machine: t.Name,
+		Input:   req.Input,
+		Steps:   outcome.Steps,
+		Halted:  outcome.Halted,
+		Output:  outcome.Output,
+		Failure: outcome.Failure,
+		Trace:   trace,
+	})
+}
+
+func (s *Server) serveDiagram(ctx context.Context, t *Tiler, req *serveRequest, w http.ResponseWriter) error {
+	concise := true
+	if req.Concise != nil {
+		concise = *req.Concise
+	}
+	g := t.StateGraph(concise)
+
+	var buf bytes.Buffer
+	format := strings.ToLower(req.Format)
+	switch format {
+	case "", "svg":
+		format = "svg"
+		g.WriteSVG(&buf)
+	case "dot":
+		g.WriteDOT(&buf)
+	case "png":
+		_, width, height := g.Layout()
+		if w, h := int(width), int(height); s.MaxPixels > 0 && w*h > s.MaxPixels {
+			return serveErrorf(http.StatusBadRequest, "the image would be %dx%d, more than %d pixels", w, h, s.MaxPixels)
+		}
+		meta := []imageMeta{{"Software", "turing-tiler"}, {"Machine", t.Name}}
+		if err := encodePNG(ctx, &buf, t.diagramImage(g), meta, &t.Options); err != nil {
+			return err
+		}
+	default:
+		return serveErrorf(http.StatusBadRequest, "unknown diagram format %q, expected svg, dot or png", req.Format)
+	}
+	w.Header().Set("Content-Type", serveTypes[format])
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,254 @@
+package tiler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// the state of a running machine between steps
+type Config struct {
+	Tape   []rune
+	Head   int
+	State  string
+	Halted bool
+	Output string
+}
+
+// Simulator runs a machine directly on a tape, without assembling tiles. its
+// configuration after each step matches the corresponding row of an assembly.
+type Simulator struct {
+	Config
+	Steps int
+
+	transitions map[twople]*Transition
+	recording   bool
+	history     []StepRecord // one per step taken while recording
+}
+
+// StepRecord is what a step changed, which is enough to undo it
+type StepRecord struct {
+	Transition *Transition // whose OldState is the state before
+	Head       int         // where the head was, and so the cell written
+	Overwrote  rune        // the symbol the cell held before
+}
+
+func (t *Tiler) NewSimulator(input string) *Simulator {
+	// wrap with boundary symbols at beginning and end
+	tape := []rune{t.BoundarySymbol}
+	tape = append(tape, []rune(input)...)
+	tape = append(tape, t.BoundarySymbol)
+
+	s := Simulator{
+		Config: Config{
+			Tape:  tape,
+			Head:  t.InitialLocation + 1,
+			State: t.InitialState,
+		},
+		transitions: make(map[twople]*Transition),
+	}
+	for i := range t.Transitions {
+		trans := &t.Transitions[i]
+		s.transitions[twople{trans.OldState, trans.ReadSymbol}] = trans
+	}
+	return &s
+}
+
+// simulateRows runs the simulator on an input, passing emit the configuration
+// before the first step and after each one. like assembleRows, it warns and
+// returns false if the run goes past MaxDepth. the configuration passed to
+// emit is overwritten by the next step.
+func (t *Tiler) simulateRows(input string, emit func(conf *Config)) bool {
+	completed, _ := t.simulateRowsContext(context.Background(), input, emit)
+	return completed
+}
+
+// simulateRowsContext is simulateRows, but gives up with ctx's error once ctx
+// is done
+func (t *Tiler) simulateRowsContext(ctx context.Context, input string, emit func(conf *Config)) (bool, error) {
+	s := t.NewSimulator(input)
+	emit(&s.Config)
+	for {
+		if t.atMaxDepth(s) {
+			log.Printf("  Warning: simulation hit maximum depth (%d), increase with -max-depth", t.MaxDepth)
+			return false, nil
+		}
+		if !s.Step() {
+			break
+		}
+		// checking every step would slow down long runs
+		if s.Steps%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+		}
+		emit(&s.Config)
+	}
+	if !s.Halted {
+		log.Printf("  Warning: machine stalled in state %s after %d steps", s.State, s.Steps)
+	}
+	return true, nil
+}
+
+// atMaxDepth says whether a run has taken the MaxDepth steps it may and would
+// take another. a machine which halts on the last of them hasn't hit the depth.
+func (t *Tiler) atMaxDepth(s *Simulator) bool {
+	return t.MaxDepth > 0 && s.Steps >= t.MaxDepth && s.Transition() != nil
+}
+
+// Transition returns the transition which the next step would apply, or nil
+// if there is none
+func (s *Simulator) Transition() *Transition {
+	if s.Halted || s.Head < 0 || s.Head >= len(s.Tape) {
+		return nil
+	}
+	return s.transitions[twople{s.State, string(s.Tape[s.Head])}]
+}
+
+// Step applies a single transition. it returns false without changing anything
+// if the machine has halted, has no transition for its state and symbol, or
+// would move off the edge of the tape (where no tiles could be placed).
+func (s *Simulator) Step() bool {
+	trans := s.Transition()
+	if trans == nil {
+		return false
+	}
+	head := s.Head
+	switch trans.Move {
+	case Left:
+		head--
+	case Right:
+		head++
+	}
+	if head < 0 || head >= len(s.Tape) {
+		return false
+	}
+
+	if s.recording {
+		s.history = append(s.history, StepRecord{trans, s.Head, s.Tape[s.Head]})
+	}
+	s.Tape[s.Head], _ = utf8.DecodeRuneInString(trans.WriteSymbol)
+	s.Head = head
+	s.State = trans.NewState
+	if trans.Move == Halt {
+		s.Halted = true
+		s.Output = trans.Output
+	}
+	s.Steps++
+	return true
+}
+
+// Record starts keeping a history of steps, so that they can be undone. steps
+// taken before it was called can't be.
+func (s *Simulator) Record() {
+	s.recording = true
+}
+
+// History is the record of each step taken since Record, oldest first
+func (s *Simulator) History() []StepRecord {
+	return s.history
+}
+
+// Back undoes the last recorded step, returning false if there is none
+func (s *Simulator) Back() bool {
+	if len(s.history) == 0 {
+		return false
+	}
+	last := s.history[len(s.history)-1]
+	s.history = s.history[:len(s.history)-1]
+	s.Tape[last.Head] = last.Overwrote
+	s.Head = last.Head
+	s.State = last.Transition.OldState
+	s.Halted, s.Output = false, ""
+	s.Steps--
+	return true
+}
+
+// Seek goes back or forward to a step number, stopping early if the history
+// or the run runs out. it returns whether the step was reached.
+func (s *Simulator) Seek(step int) bool {
+	for s.Steps > step && s.Back() {
+	}
+	for s.Steps < step && s.Step() {
+	}
+	return s.Steps == step
+}
+
+// LastWrite finds the most recent recorded step which wrote to a cell, and
+// the step number it took the machine to, returning nil if none did
+func (s *Simulator) LastWrite(cell int) (*StepRecord, int) {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].Head == cell {
+			return &s.history[i], s.Steps - len(s.history) + i + 1
+		}
+	}
+	return nil, 0
+}
+
+// WriteSimulation runs the simulator on an input, printing each configuration
+// to w as a numbered line with the state and head position, followed by a caret
+// under the head. with clear the screen is cleared before each step, with all
+// unset steps which don't change the tape overwrite their line, and delay
+// pauses between steps so that a run can be watched. long runs are checkpointed
+// and resumed as CheckpointInterval and Resume say. it returns whether the
+// machine halted. the checkpoint is removed once the run ends, but kept if it
+// dies.
+func (t *Tiler) WriteSimulation(w io.Writer, input string, delay time.Duration, clear, all bool) bool {
+	s := t.NewSimulator(input)
+	if t.CheckpointInterval <= 0 && !t.Resume {
+		return t.writeSteps(w, s, "", input, delay, clear, all)
+	}
+	file := t.outputFile(input, "-sim", "checkpoint")
+	t.resumeSimulation(s, file, input)
+	halted := t.writeSteps(w, s, file, input, delay, clear, all)
+	os.Remove(file)
+	return halted
+}
+
+// writeSteps runs a simulator on to the end for WriteSimulation, saving it to
+// file as it goes
+func (t *Tiler) writeSteps(w io.Writer, s *Simulator, file, input string, delay time.Duration, clear, all bool) bool {
+	saved := time.Now()
+	fmt.Fprintf(w, "%4d (%2s/%2d) %s\n", s.Steps, s.State, s.Head, string(s.Tape))
+	fmt.Fprintf(w, "             %s^\n\n", strings.Repeat(" ", s.Head))
+	for {
+		trans := s.Transition()
+		if trans == nil {
+			fmt.Fprintf(w, "%4d (%2s/%2d) Stalled: no transition\n", s.Steps, s.State, s.Head)
+			return false
+		}
+		if t.atMaxDepth(s) {
+			log.Printf("  Warning: simulation hit maximum depth (%d), increase with -max-depth", t.MaxDepth)
+			return false
+		}
+		read := string(s.Tape[s.Head])
+		if !s.Step() {
+			fmt.Fprintf(w, "%4d (%2s/%2d) Stalled: off the edge of the tape\n", s.Steps, s.State, s.Head)
+			return false
+		}
+		if s.Halted {
+			fmt.Fprintf(w, "%4d (%2s/%2d) Halt: '%s'\n", s.Steps, s.State, s.Head, s.Output)
+			return true
+		}
+
+		if clear {
+			io.WriteString(w, "\x1b[H\x1b[2J")
+		}
+		end := "\n"
+		if !all && read == trans.WriteSymbol {
+			end = "\r"
+		}
+		fmt.Fprintf(w, "%4d (%2s/%2d) %s%s", s.Steps, s.State, s.Head, string(s.Tape), end)
+		fmt.Fprintf(w, "             %s^\n\n", strings.Repeat(" ", s.Head))
+		if t.CheckpointInterval > 0 && time.Since(saved) >= t.CheckpointInterval {
+			t.saveSimulation(s, file, input)
+			saved = time.Now()
+		}
+		time.Sleep(delay)
+	}
+}
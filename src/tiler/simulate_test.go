@@ -0,0 +1,153 @@
+package tiler
+
+import (
+	"strings"
+	"testing"
+)
+
+// inverts its input, then steps back and halts on the last cell
+const invertMachine = `
+SYMBOL *
+SYMBOL 0
+SYMBOL 1
+TRANSITION 1 0 1 r 1
+TRANSITION 1 1 0 r 1
+TRANSITION 1 * * l 2
+TRANSITION 2 0 0 h 3 done
+TRANSITION 2 1 1 h 3 done
+`
+
+// a simulator of invertMachine on 011, recording from step record
+func newInvertSimulator(t *testing.T, record int) *Simulator {
+	tiler := &Tiler{Options: Options{BoundarySymbol: '*'}}
+	tiler.Machine = tiler.parseMachine(strings.NewReader(invertMachine), "invert")
+	s := tiler.NewSimulator("011")
+	for s.Steps < record {
+		if !s.Step() {
+			t.Fatalf("the run ended at step %d, before recording from %d", s.Steps, record)
+		}
+	}
+	s.Record()
+	return s
+}
+
+// the configuration after each step of invertMachine on 011
+var invertRun = []Config{
+	{Tape: []rune("*011*"), Head: 1, State: "1"},
+	{Tape: []rune("*111*"), Head: 2, State: "1"},
+	{Tape: []rune("*101*"), Head: 3, State: "1"},
+	{Tape: []rune("*100*"), Head: 4, State: "1"},
+	{Tape: []rune("*100*"), Head: 3, State: "2"},
+	{Tape: []rune("*100*"), Head: 3, State: "3", Halted: true, Output: "done"},
+}
+
+func checkConfig(t *testing.T, s *Simulator, step int) {
+	t.Helper()
+	want := invertRun[step]
+	if s.Steps != step || string(s.Tape) != string(want.Tape) || s.Head != want.Head ||
+		s.State != want.State || s.Halted != want.Halted || s.Output != want.Output {
+		t.Errorf("at step %d: tape %s, head %d, state %s, halted %v with %q; want step %d: tape %s, head %d, state %s, halted %v with %q",
+			s.Steps, string(s.Tape), s.Head, s.State, s.Halted, s.Output,
+			step, string(want.Tape), want.Head, want.State, want.Halted, want.Output)
+	}
+}
+
+func TestBack(t *testing.T) {
+	tests := []struct {
+		name          string
+		record, from  int
+		backs         int
+		wantReached   bool
+		wantStep      int
+		wantHistories int
+	}{
+		{"undoes the halt", 0, 5, 1, true, 4, 4},
+		{"undoes every step", 0, 5, 5, true, 0, 0},
+		{"stops where recording started", 2, 5, 4, false, 2, 0},
+		{"has nothing to undo", 3, 3, 1, false, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newInvertSimulator(t, tt.record)
+			s.Seek(tt.from)
+			reached := true
+			for i := 0; i < tt.backs; i++ {
+				reached = s.Back()
+			}
+			if reached != tt.wantReached {
+				t.Errorf("last Back returned %v, want %v", reached, tt.wantReached)
+			}
+			checkConfig(t, s, tt.wantStep)
+			if len(s.History()) != tt.wantHistories {
+				t.Errorf("%d steps in the history, want %d", len(s.History()), tt.wantHistories)
+			}
+		})
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tests := []struct {
+		name         string
+		record, from int
+		to           int
+		wantReached  bool
+		wantStep     int
+	}{
+		{"forward", 0, 0, 3, true, 3},
+		{"back", 0, 5, 1, true, 1},
+		{"to where it is", 0, 2, 2, true, 2},
+		{"past the halt", 0, 0, 9, false, 5},
+		{"back past recording", 2, 4, 0, false, 2},
+		{"back then forward", 0, 5, 2, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newInvertSimulator(t, tt.record)
+			if !s.Seek(tt.from) {
+				t.Fatalf("couldn't seek to step %d", tt.from)
+			}
+			if reached := s.Seek(tt.to); reached != tt.wantReached {
+				t.Errorf("Seek(%d) returned %v, want %v", tt.to, reached, tt.wantReached)
+			}
+			checkConfig(t, s, tt.wantStep)
+		})
+	}
+}
+
+func TestLastWrite(t *testing.T) {
+	tests := []struct {
+		name          string
+		record, at    int
+		cell          int
+		wantFound     bool
+		wantStep      int
+		wantOverwrote rune
+	}{
+		{"first cell", 0, 5, 1, true, 1, '0'},
+		{"written twice", 0, 5, 3, true, 5, '0'},
+		{"written once of two", 0, 4, 3, true, 3, '1'},
+		{"boundary", 0, 5, 4, true, 4, '*'},
+		{"never written", 0, 5, 0, false, 0, 0},
+		{"written before recording", 2, 5, 1, false, 0, 0},
+		{"written after recording", 2, 5, 4, true, 4, '*'},
+		{"undone", 0, 2, 3, false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newInvertSimulator(t, tt.record)
+			s.Seek(5)
+			s.Seek(tt.at)
+			record, step := s.LastWrite(tt.cell)
+			if (record != nil) != tt.wantFound {
+				t.Fatalf("LastWrite(%d) found %v, want %v", tt.cell, record, tt.wantFound)
+			}
+			if record == nil {
+				return
+			}
+			if step != tt.wantStep || record.Overwrote != tt.wantOverwrote || record.Head != tt.cell {
+				t.Errorf("LastWrite(%d) = step %d overwriting %q at %d, want step %d overwriting %q",
+					tt.cell, step, record.Overwrote, record.Head, tt.wantStep, tt.wantOverwrote)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package tiler
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"io"
+	"log"
+	"os"
+)
+
+// pngStream encodes an RGBA PNG one scanline at a time, so that an image
+// needn't ever be held in memory. the height isn't known until the end, so it's
+// patched into the header when the stream is closed.
+type pngStream struct {
+	file          *os.File
+	width, height int
+	buf           *bufio.Writer
+	z             *zlib.Writer
+	line          []byte
+}
+
+const (
+	pngSignature    = "\x89PNG\r\n\x1a\n"
+	pngHeightOffset = 8 + 8 + 4  // after the signature, IHDR length and type, and width
+	pngIHDRCRC      = 8 + 8 + 13 // after the signature, IHDR length and type, and IHDR data
+	pngIDATSize     = 1 << 16    // bytes of compressed data per IDAT chunk
+)
+
+func newPNGStream(file string, width int) *pngStream {
+	f, err := os.Create(file)
+	if err != nil {
+		log.Panicf("Couldn't create %s: %s", file, err)
+	}
+	p := pngStream{
+		file:  f,
+		width: width,
+		line:  make([]byte, 1+4*width),
+	}
+	io.WriteString(f, pngSignature)
+	p.writeChunk("IHDR", p.header())
+	p.buf = bufio.NewWriterSize(chunkWriter{&p, "IDAT"}, pngIDATSize)
+	p.z = zlib.NewWriter(p.buf)
+	return &p
+}
+
+func (p *pngStream) header() []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(p.width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(p.height))
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // color type: RGBA
+	return ihdr
+}
+
+func (p *pngStream) writeChunk(kind string, data []byte) {
+	if _, err := p.file.Write(pngChunk(kind, data)); err != nil {
+		log.Panicf("Couldn't write %s: %s", p.file.Name(), err)
+	}
+}
+
+// each buffered write of compressed data becomes its own chunk
+type chunkWriter struct {
+	p    *pngStream
+	kind string
+}
+
+func (c chunkWriter) Write(data []byte) (int, error) {
+	c.p.writeChunk(c.kind, data)
+	return len(data), nil
+}
+
+// writeLine appends a scanline of RGBA pixels, unfiltered
+func (p *pngStream) writeLine(pix []byte) {
+	p.line[0] = 0
+	copy(p.line[1:], pix)
+	if _, err := p.z.Write(p.line); err != nil {
+		log.Panicf("Couldn't write %s: %s", p.file.Name(), err)
+	}
+	p.height++
+}
+
+// close finishes the image data, adds the metadata, and fixes up the header
+// with the real height
+func (p *pngStream) close(meta []imageMeta) {
+	if err := p.z.Close(); err != nil {
+		log.Panicf("Couldn't write %s: %s", p.file.Name(), err)
+	}
+	if err := p.buf.Flush(); err != nil {
+		log.Panicf("Couldn't write %s: %s", p.file.Name(), err)
+	}
+	for _, m := range meta {
+		p.writeChunk("iTXt", iTXt(m))
+	}
+	p.writeChunk("IEND", nil)
+
+	ihdr := p.header()
+	crc := crc32.NewIEEE()
+	io.WriteString(crc, "IHDR")
+	crc.Write(ihdr)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	if _, err := p.file.WriteAt(ihdr[4:8], pngHeightOffset); err != nil {
+		log.Panicf("Couldn't write %s: %s", p.file.Name(), err)
+	}
+	if _, err := p.file.WriteAt(sum[:], pngIHDRCRC); err != nil {
+		log.Panicf("Couldn't write %s: %s", p.file.Name(), err)
+	}
+
+	if err := p.file.Close(); err != nil {
+		log.Panicf("Couldn't write %s: %s", p.file.Name(), err)
+	}
+}
+
+// streaming needs rows of tiles to arrive in the order they're drawn, i.e. time
+// must run down the image. mirrored reports whether the rows are also flipped
+// left to right.
+func (t *Tiler) streamable() (ok, mirrored bool) {
+	switch {
+	case t.Rotation == 0 && t.FlipVertical:
+		return true, t.FlipHorizontal
+	case t.Rotation == 2 && !t.FlipVertical:
+		return true, !t.FlipHorizontal
+	}
+	return false, false
+}
+
+// streamOne assembles a single input straight into PNG scanlines, so that
+// memory is bounded by the tile pool and a few rows of tiles however deep the
+// assembly goes. with PagePixels set the output is split into numbered pages of
+// at most that many pixels each.
+func (t *Tiler) streamOne(input string) Outcome {
+	ok, mirrored := t.streamable()
+	if !ok {
+		log.Panicf("Streaming needs time to run down the image; use -flip-vertical or -rotation 2")
+	}
+	if t.imageFormat() != "png" {
+		log.Panicf("Streaming only writes png images")
+	}
+
+	sizeX := len([]rune(input)) + 2
+	width := t.TileWidth*sizeX - sizeX + 1
+
+	// each row of tiles adds TileHeight-1 lines to the first row's TileHeight
+	pageRows := 0
+	if t.PagePixels > 0 {
+		pageRows = (t.PagePixels/width - 1) / (t.TileHeight - 1)
+		if pageRows < 1 {
+			pageRows = 1
+		}
+	}
+
+	var (
+		files []string
+		out   *pngStream
+		rows  int
+		last  []*Tile
+	)
+	// each page records the run up to its last row
+	meta := func() []imageMeta { return t.runMeta(input, rows-1, tileRowConfig(last)) }
+	line := make([]byte, 4*width)
+	lineImage := &image.RGBA{Pix: line, Stride: len(line), Rect: image.Rect(0, 0, width, 1)}
+
+	emit := func(row []*Tile) {
+		if out == nil || (pageRows > 0 && rows%pageRows == 0) {
+			if out != nil {
+				out.close(meta())
+			}
+			file := t.outputFile(input, "", "png")
+			if pageRows > 0 {
+				file = t.outputFile(input, fmt.Sprintf("-%03d", len(files)+1), "png")
+			}
+			log.Printf("Streaming image %s...", file)
+			out = newPNGStream(file, width)
+			files = append(files, file)
+		}
+
+		// neighboring tiles overlap by one pixel; as in the composite, the
+		// upper and right tiles win. the first row on a page keeps its top line.
+		first := 1
+		if out.height == 0 {
+			first = 0
+		}
+		for y := first; y < t.TileHeight; y++ {
+			for i := range line {
+				line[i] = 0
+			}
+			for j := range row {
+				tile := row[j]
+				if mirrored {
+					tile = row[len(row)-1-j]
+				}
+				if tile == nil {
+					continue // silently ignore missing tiles
+				}
+				x := t.TileWidth*j - j
+				r := image.Rect(x, 0, x+t.TileWidth, 1)
+				draw.Draw(lineImage, r, tile.Image, image.Pt(0, y), draw.Src)
+			}
+			out.writeLine(line)
+		}
+		rows++
+		last = row
+	}
+
+	completed := t.assembleCheckpointed(input, emit)
+	if out != nil {
+		out.close(meta())
+	}
+	outcome := t.outcome(input, rows-1, tileRowConfig(last), completed)
+	if !completed && !t.IgnoreDepthFailure {
+		for _, file := range files {
+			os.Remove(file)
+		}
+		return outcome
+	}
+	log.Printf("Done!")
+	return outcome
+}
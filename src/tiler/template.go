@@ -0,0 +1,69 @@
+package tiler
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// WriteTemplate writes a skeleton machine named name to name.machine under
+// OutputDir, declaring the symbols (and the boundary symbol) and a halting
+// transition for every state and symbol, to be edited into the real thing. it
+// won't overwrite an existing file.
+func (o *Options) WriteTemplate(name string, states int, symbols []rune) string {
+	dir := o.OutputDir
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Panicf("Couldn't create %s: %s", dir, err)
+	}
+	file := filepath.Join(dir, name+".machine")
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		log.Panicf("Couldn't create %s: %s", file, err)
+	}
+	defer f.Close()
+
+	symbols = append(symbols, o.BoundarySymbol)
+	fmt.Fprintf(f, "NAME %s\n\nSTATES %d\n\n", name, states)
+	for _, symbol := range symbols {
+		fmt.Fprintf(f, "SYMBOL %s\n", string(symbol))
+	}
+	for state := 1; state <= states; state++ {
+		fmt.Fprintln(f)
+		for _, symbol := range symbols {
+			fmt.Fprintf(f, "TRANSITION %d %s %s h %d\n", state, string(symbol), string(symbol), state)
+		}
+	}
+	return file
+}
+
+// WriteSeed writes the tape specification of an input, as aux/seedgen.pl
+// reads it: the input's cells between boundary cells, with the head on the
+// cell where it starts.
+func (t *Tiler) WriteSeed(input string) {
+	if !t.validInput(input) {
+		log.Panicf("Invalid symbol encountered in input string %q", input)
+	}
+
+	file := t.outputFile(input, "", "seed")
+	log.Printf("Saving seed %s...", file)
+	f, err := os.Create(file)
+	if err != nil {
+		log.Panicf("Couldn't create %s: %s", file, err)
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "BASE %s\n", input)
+	fmt.Fprintf(f, "CELL %s\n", string(t.BoundarySymbol))
+	for i, symbol := range []rune(input) {
+		if i == t.InitialLocation {
+			fmt.Fprintf(f, "HEAD %s %s\n", t.InitialState, string(symbol))
+		} else {
+			fmt.Fprintf(f, "CELL %s\n", string(symbol))
+		}
+	}
+	fmt.Fprintf(f, "CELL %s\n", string(t.BoundarySymbol))
+}
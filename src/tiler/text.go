@@ -0,0 +1,125 @@
+package tiler
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI escapes used when writing to a terminal
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiUnderline = "\x1b[4m"
+	ansiReverse   = "\x1b[7m"
+)
+
+// what a tile contributes to the tape after its row's step
+type textCell struct {
+	symbol, state string
+	head, final   bool
+}
+
+// a tile's top bond carries the tape contents it leaves behind: "state symbol"
+// under a double bond if the head moved onto it, or the written symbol
+// (possibly with halting output) otherwise
+func tileTextCell(tile *Tile) *textCell {
+	if tile == nil {
+		return nil
+	}
+	up := tile.Sides[Up]
+	c := textCell{symbol: up.Label, final: tile.Final}
+	if up.Strength >= 2 {
+		if n := strings.LastIndex(up.Label, " "); n >= 0 {
+			c.state, c.symbol = up.Label[:n], up.Label[n+1:]
+			c.head = true
+		}
+	}
+	return &c
+}
+
+func (c *textCell) String() string {
+	if c.head {
+		return c.state + ":" + c.symbol
+	}
+	return c.symbol
+}
+
+// WriteText renders an already rotated assembly as one line of text per row
+// of tiles, in the same orientation as the composite image. with ansi set the
+// head and halting cells are highlighted in their bond colors; otherwise the
+// head is bracketed and halting cells are braced.
+func (t *Tiler) WriteText(w io.Writer, assembly Assembly, ansi bool) {
+	width := 1
+	for _, row := range assembly {
+		for _, tile := range row {
+			if c := tileTextCell(tile); c != nil {
+				if n := utf8.RuneCountInString(c.String()) + 2; n > width {
+					width = n
+				}
+			}
+		}
+	}
+
+	// row 0 is at the bottom of the image, so print from the end
+	for i := len(assembly) - 1; i >= 0; i-- {
+		var line []string
+		for _, tile := range assembly[i] {
+			line = append(line, t.formatTextCell(tileTextCell(tile), width, ansi))
+		}
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(line, " "), " "))
+	}
+}
+
+func (t *Tiler) formatTextCell(c *textCell, width int, ansi bool) string {
+	var text string
+	switch {
+	case c == nil:
+		text = "."
+		if ansi {
+			text = "·"
+		}
+	case ansi:
+		text = c.String()
+	case c.head:
+		text = "[" + c.String() + "]"
+	case c.final:
+		text = "{" + c.String() + "}"
+	default:
+		text = c.String()
+	}
+
+	// pad to a centered, fixed-width column
+	pad := width - utf8.RuneCountInString(text)
+	text = strings.Repeat(" ", pad/2) + text + strings.Repeat(" ", pad-pad/2)
+
+	if !ansi || c == nil {
+		return text
+	}
+	switch {
+	case c.head:
+		col := t.bondColor(Up, Bond{2, c.state + " " + c.symbol})
+		return ansiReverse + ansiBold + ansiForeground(col) + text + ansiReset
+	case c.final:
+		col := t.bondColor(Up, Bond{1, c.symbol})
+		return ansiBold + ansiUnderline + ansiForeground(col) + text + ansiReset
+	}
+	return text
+}
+
+func ansiForeground(c color.RGBA) string {
+	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", c.R, c.G, c.B)
+}
+
+// isTerminal reports whether f is attached to a character device rather than
+// a file or pipe
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
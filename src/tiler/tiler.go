@@ -4,20 +4,41 @@ import (
 	"fmt"
 	"image"
 	"log"
+	"time"
 )
 
 type Options struct {
 	TileHeight, TileWidth        int
 	MaxDepth                     int
 	IgnoreDepthFailure           bool
-	FontPath                     string
+	FontPath                     string   // "" for the bundled font
+	FallbackFonts                []string // tried in order for glyphs FontPath lacks, before the bundled font
 	FontSize                     float64
-	Rotation                     int // 0 is best for portrait or web (top->down), 3 is best for landscape or monitors (left->right)
+	MinFontSize                  float64 // labels shrink to fit their tile down to this size
+	AutoSize                     bool    // size tiles to fit the longest labels at FontSize
+	ClassicLabels                bool    // place labels as assemble.pl did, by the size of a '5', never shrunk
+	Rotation                     int     // 0 is best for portrait or web (top->down), 3 is best for landscape or monitors (left->right)
 	FlipHorizontal, FlipVertical bool
 	BoundarySymbol               rune
 	MachineFile                  string
 	Inputs                       []string
 	ColorTweak                   string
+	Palette                      string // "hash" (the default), "colorblind" or "grayscale"
+	Theme                        string // file of colors overriding the palette's
+	TextOutput                   bool
+	Compact                      string // "simulator" or "assembler" to draw a pixel per cell instead of tiles
+	Stream                       bool
+	PagePixels                   int // split streamed images into pages of at most this many pixels; 0 for one image
+	Pyramid                      bool
+	Report                       bool
+	Legend                       bool          // add a key to bond colors to the composite image
+	Trajectory                   bool          // draw the head's path over the composite image
+	OutputDir                    string        // everything is written under here; "" for the current directory
+	NameTemplate                 string        // see DefaultNameTemplate for the placeholders
+	Format                       string        // image format: png, jpeg, gif, bmp or tiff; "" for png
+	Quality                      int           // jpeg quality from 1-100
+	CheckpointInterval           time.Duration // how often to save a checkpoint of a run to resume from; 0 for never
+	Resume                       bool          // carry on from a run's checkpoint, if it has one
 }
 
 type Tiler struct {
@@ -42,6 +63,13 @@ func (o *Options) NewTiler() *Tiler {
 	return &t
 }
 
+// NewMachineTiler only parses the machine, for commands that never make tiles
+func (o *Options) NewMachineTiler() *Tiler {
+	t := Tiler{Options: *o}
+	t.Machine = t.ParseMachine()
+	return &t
+}
+
 type Direction int
 
 const (
@@ -52,6 +80,15 @@ const (
 	Halt
 )
 
+var directionNames = []string{"left", "right", "up", "down", "halt"}
+
+func (d Direction) String() string {
+	if int(d) < len(directionNames) {
+		return directionNames[d]
+	}
+	return fmt.Sprintf("Direction(%d)", int(d))
+}
+
 type Tile struct {
 	Name  string
 	Sides Bonds
@@ -71,7 +108,7 @@ func (t *Tiler) GenerateTiles() {
 	log.Println("Generating tileset 1/3...")
 	for _, trans := range t.Transitions {
 		tile := Tile{
-			Name: fmt.Sprintf("%s-%s", trans.OldState, trans.ReadSymbol),
+			Name: fmt.Sprintf("transition-%s-%s", trans.OldState, trans.ReadSymbol),
 		}
 		switch trans.Move {
 		case Left:
@@ -84,7 +121,7 @@ func (t *Tiler) GenerateTiles() {
 			tile.Sides = Bonds{
 				Up:    Bond{1, trans.WriteSymbol},
 				Left:  Bond{1, "L"},
-				Right: Bond{1, "R"},
+				Right: Bond{1, trans.NewState},
 			}
 		case Halt:
 			var label string
@@ -170,14 +207,21 @@ func (t *Tiler) GenerateTiles() {
 	t.tileIndexBottom = make(map[string]*Tile)
 	t.tileIndexLeft = make(map[twople]*Tile)
 	t.tileIndexRight = make(map[twople]*Tile)
-	for _, tile := range t.tiles {
-		t.tileIndexBottom[tile.Sides[Down].Label] = &tile
-		t.tileIndexLeft[twople{tile.Sides[Left].Label, tile.Sides[Down].Label}] = &tile
-		t.tileIndexRight[twople{tile.Sides[Right].Label, tile.Sides[Down].Label}] = &tile
+	for i := range t.tiles {
+		tile := &t.tiles[i]
+		t.tileIndexBottom[tile.Sides[Down].Label] = tile
+		t.tileIndexLeft[twople{tile.Sides[Left].Label, tile.Sides[Down].Label}] = tile
+		t.tileIndexRight[twople{tile.Sides[Right].Label, tile.Sides[Down].Label}] = tile
 	}
 
+	if t.AutoSize {
+		t.autoSize()
+	}
+
+	t.setupPalette()
+
 	log.Println("Drawing tile images...")
-	for _, tile := range t.tiles {
-		tile.Image = t.generateImage(&tile)
+	for i := range t.tiles {
+		t.tiles[i].Image = t.generateImage(&t.tiles[i])
 	}
 }
@@ -0,0 +1,87 @@
+package tiler
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+// the three tilesets of GenerateTiles, by the prefix of their tiles' names
+var tilesetGroups = []string{"transition", "move", "replicate"}
+
+// WriteTileset saves every tile in the pool to one sheet, named as the output
+// for an input of "tileset", grouped by tileset under a heading with each
+// tile's name beneath it. the "rows" layout gives each tileset a single row;
+// "square" wraps them all at the same width so that the sheet comes out
+// roughly square.
+func (t *Tiler) WriteTileset(layout string) {
+	groups := make([][]*Tile, len(tilesetGroups))
+	for i := range t.tiles {
+		tile := &t.tiles[i]
+		for g, prefix := range tilesetGroups {
+			if strings.HasPrefix(tile.Name, prefix+"-") {
+				groups[g] = append(groups[g], tile)
+			}
+		}
+	}
+
+	cols := 0
+	switch layout {
+	case "rows":
+		for _, group := range groups {
+			if len(group) > cols {
+				cols = len(group)
+			}
+		}
+	case "square":
+		cols = int(math.Ceil(math.Sqrt(float64(len(t.tiles)))))
+	default:
+		log.Panicf("Unknown tileset layout %q, expected rows or square", layout)
+	}
+
+	heading := int(math.Ceil(t.FontSize * 1.5))
+	nameSize := t.FontSize * 0.8
+	nameHeight := int(math.Ceil(nameSize * 1.5))
+	cellW := t.TileWidth + legendPadding
+	cellH := t.TileHeight + nameHeight + legendPadding
+
+	height := legendPadding
+	for _, group := range groups {
+		height += heading + (len(group)+cols-1)/cols*cellH
+	}
+	im := image.NewRGBA(image.Rect(0, 0, cols*cellW+legendPadding, height))
+	draw.Draw(im, im.Bounds(), image.White, image.ZP, draw.Src)
+
+	black := color.RGBA{0, 0, 0, 255}
+	y := legendPadding
+	for g, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i].Name < group[j].Name })
+		t.drawText(im, black, fmt.Sprintf("%s (%d)", tilesetGroups[g], len(group)), t.FontSize,
+			legendPadding, y+int(float64(heading)+capHeight*t.FontSize)/2)
+		y += heading
+
+		for i, tile := range group {
+			x := legendPadding + i%cols*cellW
+			top := y + i/cols*cellH
+			draw.Draw(im, image.Rect(x, top, x+t.TileWidth, top+t.TileHeight), tile.Image, image.ZP, draw.Src)
+
+			// drop the tileset prefix, and shrink names to fit under the tile
+			name := strings.TrimPrefix(tile.Name, tilesetGroups[g]+"-")
+			size := nameSize
+			if w := t.textWidth(name, size); w > float64(t.TileWidth) {
+				size = math.Max(size*float64(t.TileWidth)/w, t.MinFontSize)
+			}
+			nx := x + int(float64(t.TileWidth)-t.textWidth(name, size))/2
+			t.drawText(im, black, name, size, nx, top+t.TileHeight+int(float64(nameHeight)+capHeight*size)/2)
+		}
+		y += (len(group) + cols - 1) / cols * cellH
+	}
+
+	t.saveImage(t.outputFile("tileset", "", t.imageFormat()), im,
+		imageMeta{"Software", "turing-tiler"}, imageMeta{"Machine", t.Name})
+}
@@ -0,0 +1,101 @@
+package tiler
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// where the head is after a row of the assembly
+type headPoint struct {
+	row, col int
+	*Config
+}
+
+// headTrail finds the head in each row of an unrotated assembly. rows where
+// it can't be found are left out, which breaks the path there.
+func headTrail(assembly Assembly) []headPoint {
+	var trail []headPoint
+	for j, row := range assembly {
+		if conf := tileRowConfig(row); conf.Head >= 0 {
+			trail = append(trail, headPoint{j, conf.Head, conf})
+		}
+	}
+	return trail
+}
+
+// drawTrajectory overlays a path through the center of the head's tile on
+// each row onto a composite, each segment in the color of the state the head
+// was in as it moved, brightened as in compact images. the halting tile is
+// ringed and labeled with the output. sizeX and sizeY are those of the
+// assembly before rotation.
+func (t *Tiler) drawTrajectory(im *image.RGBA, trail []headPoint, sizeX, sizeY int) {
+	rotY := sizeY
+	if t.Rotation%2 == 1 {
+		rotY = sizeX
+	}
+	center := func(p headPoint) [2]float64 {
+		rj, ri := t.rotatedPosition(p.row, p.col, sizeX, sizeY)
+		return [2]float64{
+			float64(ri*(t.TileWidth-1)) + float64(t.TileWidth)/2,
+			float64((rotY-1-rj)*(t.TileHeight-1)) + float64(t.TileHeight)/2,
+		}
+	}
+	width := math.Max(2, float64(t.TileWidth)/10)
+
+	// one mask per color, so that overlapping strokes don't darken
+	masks := make(map[color.RGBA]*image.Alpha)
+	mask := func(c color.RGBA) *image.Alpha {
+		if masks[c] == nil {
+			masks[c] = image.NewAlpha(im.Bounds())
+		}
+		return masks[c]
+	}
+	var order []color.RGBA
+	for k, p := range trail {
+		c := brighten(t.bondColor(Left, Bond{1, p.State}))
+		if _, exists := masks[c]; !exists {
+			order = append(order, c)
+		}
+		m := mask(c)
+		a := center(p)
+		if k+1 < len(trail) && trail[k+1].row == p.row+1 {
+			strokeSegment(m, a, center(trail[k+1]), width)
+		}
+		strokeSegment(m, a, a, width*2) // a dot on every step
+	}
+	for _, c := range order {
+		draw.DrawMask(im, im.Bounds(), image.NewUniform(c), image.ZP, masks[c], image.ZP, draw.Over)
+	}
+
+	if len(trail) == 0 || !trail[len(trail)-1].Halted {
+		return
+	}
+	last := trail[len(trail)-1]
+	a := center(last)
+	red := color.RGBA{255, 0, 0, 255}
+	ring := image.NewAlpha(im.Bounds())
+	r := math.Min(float64(t.TileWidth), float64(t.TileHeight)) / 2
+	for i := 0; i < 64; i++ {
+		p := [2]float64{a[0] + r*math.Cos(float64(i)*math.Pi/32), a[1] + r*math.Sin(float64(i)*math.Pi/32)}
+		q := [2]float64{a[0] + r*math.Cos(float64(i+1)*math.Pi/32), a[1] + r*math.Sin(float64(i+1)*math.Pi/32)}
+		strokeSegment(ring, p, q, width)
+	}
+	draw.DrawMask(im, im.Bounds(), image.NewUniform(red), image.ZP, ring, image.ZP, draw.Over)
+
+	label := "halt"
+	if last.Output != "" {
+		label = "halt: " + last.Output
+	}
+	x, y := int(a[0]+r+width), int(a[1]+capHeight*t.FontSize/2)
+	// keep the label inside the image, to the left of the ring if need be
+	if w := t.textWidth(label, t.FontSize); float64(x)+w > float64(im.Bounds().Max.X) {
+		x = int(a[0] - r - width - w)
+	}
+	white := color.RGBA{255, 255, 255, 255}
+	for _, o := range [][2]int{{-1, -1}, {0, -1}, {1, -1}, {-1, 0}, {1, 0}, {-1, 1}, {0, 1}, {1, 1}} {
+		t.drawText(im, white, label, t.FontSize, x+o[0], y+o[1])
+	}
+	t.drawText(im, red, label, t.FontSize, x, y)
+}
@@ -0,0 +1,97 @@
+package tiler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Watch lints MachineFile and assembles each input on it, then does so again
+// whenever the machine or its inputs file changes, checking every interval.
+// with no inputs, those listed in the inputs file are used. a line per input
+// says how its run ended. bad machines and failed runs are reported and
+// watching goes on, until stop is closed.
+func (o *Options) Watch(inputs []string, interval time.Duration, w io.Writer, stop <-chan struct{}) {
+	inputsFile := strings.TrimSuffix(o.MachineFile, ".machine") + ".inputs"
+	files := []string{o.MachineFile, inputsFile}
+
+	var last string
+	for {
+		if stamp := fileStamps(files); stamp != last {
+			if last != "" {
+				fmt.Fprintf(w, "\n%s changed\n", o.MachineFile)
+			}
+			last = stamp
+			o.watchRound(inputs, inputsFile, w)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// fileStamps sums up the sizes and modification times of files, so that a
+// change to any of them changes it
+func fileStamps(files []string) string {
+	var stamps []string
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			stamps = append(stamps, fmt.Sprintf("%d/%d", info.Size(), info.ModTime().UnixNano()))
+		} else {
+			stamps = append(stamps, "-")
+		}
+	}
+	return strings.Join(stamps, " ")
+}
+
+// watchRound is one lint and assembly of every input for Watch
+func (o *Options) watchRound(inputs []string, inputsFile string, w io.Writer) {
+	fmt.Fprintf(w, "[%s] %s\n", time.Now().Format("15:04:05"), o.MachineFile)
+	if len(inputs) == 0 {
+		var cases []Case
+		if failure := catchPanic(func() { cases = readInputs(inputsFile) }); failure != "" {
+			fmt.Fprintf(w, "  bad inputs file: %s\n", failure)
+			return
+		}
+		for _, c := range cases {
+			inputs = append(inputs, c.Input)
+		}
+		if len(inputs) == 0 {
+			fmt.Fprintf(w, "  no inputs given or listed in %s\n", inputsFile)
+		}
+	}
+
+	var t *Tiler
+	if failure := catchPanic(func() {
+		o.Lint(w, false)
+		t = o.NewTiler()
+	}); failure != "" {
+		fmt.Fprintf(w, "  bad machine: %s\n", failure)
+		return
+	}
+
+	failures := 0
+	for _, input := range inputs {
+		var outcome Outcome
+		if failure := catchPanic(func() { outcome = t.AssembleOne(input) }); failure != "" {
+			outcome = Outcome{Input: input, Failure: failure}
+		}
+		switch {
+		case outcome.Failure != "" && outcome.Steps > 0:
+			fmt.Fprintf(w, "  FAIL  %s: %s after %d steps\n", input, outcome.Failure, outcome.Steps)
+			failures++
+		case outcome.Failure != "":
+			fmt.Fprintf(w, "  FAIL  %s: %s\n", input, outcome.Failure)
+			failures++
+		case outcome.Output != "":
+			fmt.Fprintf(w, "  ok    %s: halted after %d steps with %s\n", input, outcome.Steps, outcome.Output)
+		default:
+			fmt.Fprintf(w, "  ok    %s: halted after %d steps\n", input, outcome.Steps)
+		}
+	}
+	fmt.Fprintf(w, "%d inputs, %d failed; watching for changes...\n", len(inputs), failures)
+}
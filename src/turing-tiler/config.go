@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"tiler"
+)
+
+// configure fills in each flag not given on the command line from its
+// environment variable, or else from the config files for machineFile ("" for
+// none), or else its default. flags which were configured for a previous
+// machine are reset, so it can be called again for each machine in turn. it
+// returns where each flag's value came from.
+func configure(flags *flag.FlagSet, machineFile string) map[string]string {
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var files []string
+	if machineFile != "" {
+		files = tiler.ConfigFiles(machineFile)
+	}
+	values, origins := tiler.LoadConfig(files)
+	for name := range values {
+		if !knownOptions[name] {
+			log.Printf("Warning: %s: no command has an option %q", origins[name], name)
+		}
+	}
+
+	sources := make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) {
+		env := tiler.ConfigEnv(f.Name)
+		value, source := f.DefValue, "default"
+		if explicit[f.Name] {
+			sources[f.Name] = "command line"
+			return
+		} else if v, exists := os.LookupEnv(env); exists {
+			value, source = v, "$"+env
+		} else if v, exists := values[f.Name]; exists {
+			value, source = v, origins[f.Name]
+		}
+		// setting the value directly leaves the flag looking unset to Visit
+		if err := f.Value.Set(value); err != nil {
+			log.Fatalf("%s: invalid value %q for %s: %s", source, value, f.Name, err)
+		}
+		sources[f.Name] = source
+	})
+	return sources
+}
+
+// every option some command takes, so that config files can be checked for
+// ones which don't exist. set before a command runs.
+var knownOptions map[string]bool
+
+func allOptions() map[string]bool {
+	names := make(map[string]bool)
+	for _, c := range commands {
+		flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.setup(flags)
+		flags.VisitAll(func(f *flag.Flag) { names[f.Name] = true })
+	}
+	return names
+}
+
+// the config command prints the options assemble would use for a machine and
+// where each came from
+func config(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	assembleFlags(flags, &options)
+	return func() {
+		// the options come after show
+		if flags.Arg(0) != "show" {
+			log.Fatalf("usage: %s config show [options] [<machine_spec>]", "tiler")
+		}
+		flags.Parse(flags.Args()[1:])
+
+		sources := configure(flags, flags.Arg(0))
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "OPTION\tVALUE\tFROM")
+		flags.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "%s\t%q\t%s\n", f.Name, f.Value.String(), sources[f.Name])
+		})
+		w.Flush()
+	}
+}
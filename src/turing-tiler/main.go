@@ -1,39 +1,518 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"tiler"
 )
 
+// a subcommand, taking its flags from the arguments after its name
+type command struct {
+	name, summary string
+	// setup adds the command's flags, returning what to run once they're parsed
+	setup func(flags *flag.FlagSet) func()
+}
+
+var commands = []command{
+	{"assemble", "assemble tilings of machines on inputs (the default)", assemble},
+	{"watch", "assemble inputs again whenever a machine changes", watch},
+	{"simulate", "run a machine directly and print each step", simulate},
+	{"debug", "step through a run interactively, with breakpoints", debug},
+	{"diagram", "draw state diagrams of machines", diagram},
+	{"lint", "check machines for mistakes", lint},
+	{"tileset", "draw the tile pool of machines", tileset},
+	{"template", "write a skeleton machine to fill in", template},
+	{"seed", "write the tape specification of an input", seed},
+	{"diff", "compare two machines, or one machine on two inputs", diff},
+	{"run", "assemble every example in directories, as listed in .inputs files", run},
+	{"test", "check machines against the expectations in their .inputs files", test},
+	{"golden", "check that the example images still draw the same", golden},
+	{"serve", "render machines posted over HTTP", serve},
+	{"config", "show the options assemble would use for a machine, and where each is set", config},
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "-help" {
+			usage()
+			return
+		}
+		for _, c := range commands {
+			if os.Args[1] == c.name {
+				c.run(os.Args[2:])
+				return
+			}
+		}
+	}
+	// without a command, everything is taken as arguments to assemble
+	commands[0].run(os.Args[1:])
+}
+
+// run parses a command's flags from args and runs it
+func (c command) run(args []string) {
+	knownOptions = allOptions()
+	flags := flag.NewFlagSet(c.name, flag.ExitOnError)
+	body := c.setup(flags)
+	flags.Parse(args)
+	body()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [options] [arguments]\n\ncommands:\n", "tiler")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun %s <command> -h for a command's options\n", "tiler")
+}
+
+// flag values shared by every command which need converting before they go
+// into Options
+type globalValues struct {
+	boundarySymbol string
+	quiet          bool
+}
+
+// globalFlags adds the flags every command takes
+func globalFlags(flags *flag.FlagSet, options *tiler.Options) *globalValues {
+	var v globalValues
+	flags.StringVar(&v.boundarySymbol, "boundary-symbol", "*", "boundary symbol")
+	flags.StringVar(&options.OutputDir, "output-dir", "", "directory to write everything under (default the current directory)")
+	flags.BoolVar(&v.quiet, "quiet", false, "don't log progress to stderr")
+	return &v
+}
+
+func (v *globalValues) apply(options *tiler.Options) {
+	options.BoundarySymbol, _ = utf8.DecodeRune([]byte(v.boundarySymbol))
+	if v.quiet {
+		log.SetOutput(ioutil.Discard)
+	}
+}
+
+// the assemble command tiles each input in place of assemble.pl
+func assemble(flags *flag.FlagSet) func() {
 	var options tiler.Options
-	var boundarySymbol string
+	global, drawing := assembleFlags(flags, &options)
+	return func() {
+		if flags.NArg() < 2 {
+			log.Fatalf("usage: %s [assemble] [options] <machine_spec> <input_string> [<input_string>] [...]", "tiler")
+		}
+
+		configure(flags, flags.Arg(0))
+		options.MachineFile = flags.Arg(0)
+		options.Inputs = flags.Args()[1:]
+		global.apply(&options)
+		drawing.apply(&options)
+
+		log.Printf("Processing %s, %v", options.MachineFile, options.Inputs)
+		tiler := options.NewTiler()
+		tiler.Assemble()
+	}
+}
+
+// the watch command reassembles inputs as a machine is edited, until killed
+func watch(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	var interval time.Duration
+	global, drawing := assembleFlags(flags, &options)
+	flags.DurationVar(&interval, "interval", 500*time.Millisecond, "how often to check for changes")
+	return func() {
+		if flags.NArg() < 1 {
+			log.Fatalf("usage: %s watch [options] <machine_spec> [<input_string>] [...]", "tiler")
+		}
+		configure(flags, flags.Arg(0))
+		options.MachineFile = flags.Arg(0)
+		global.apply(&options)
+		drawing.apply(&options)
+		options.Watch(flags.Args()[1:], interval, os.Stdout, nil)
+	}
+}
+
+// assembleFlags adds the flags of the assemble command
+func assembleFlags(flags *flag.FlagSet, options *tiler.Options) (*globalValues, *drawingValues) {
+	global := globalFlags(flags, options)
+	drawing := drawingFlags(flags, options)
+	flags.IntVar(&options.MaxDepth, "max-depth", 100, "maximum number of transitions")
+	flags.BoolVar(&options.IgnoreDepthFailure, "ignore-depth-failure", false, "proceed when MaxDepth is exceeded")
+	flags.BoolVar(&options.TextOutput, "text", false, "also print the assembly as text on stdout")
+	flags.BoolVar(&options.Stream, "stream", false, "encode the image row by row instead of in memory (needs time running down the image)")
+	flags.IntVar(&options.PagePixels, "page-pixels", 0, "split streamed images into numbered pages of at most this many pixels")
+	flags.BoolVar(&options.Pyramid, "pyramid", false, "write a deep zoom image pyramid and viewer page instead of a single image")
+	flags.BoolVar(&options.Report, "report", false, "also write an interactive html report of each run")
+	flags.BoolVar(&options.Trajectory, "trajectory", false, "draw the head's path over the image, colored by state")
+	flags.BoolVar(&options.Legend, "legend", false, "add a legend of bond colors, states and outcome to the image")
+	flags.StringVar(&options.Compact, "compact", "", "draw one pixel per cell, streamed from the \"simulator\" or \"assembler\"")
+	checkpointFlags(flags, options)
+	return global, drawing
+}
+
+// checkpointFlags adds the flags for saving and resuming long runs
+func checkpointFlags(flags *flag.FlagSet, options *tiler.Options) {
+	flags.DurationVar(&options.CheckpointInterval, "checkpoint", 0, "save a checkpoint of each run this often, e.g. 10m, to resume from if it dies")
+	flags.BoolVar(&options.Resume, "resume", false, "carry on from each run's checkpoint, if it has one")
+}
+
+// flag values which need converting before they go into Options
+type drawingValues struct {
+	fallbackFonts string
+}
+
+// drawingFlags adds the flags for how tiles are generated and drawn, shared by
+// every command that draws them
+func drawingFlags(flags *flag.FlagSet, options *tiler.Options) *drawingValues {
+	var v drawingValues
 	// 4/3 is a reasonable aspect ratio for single-character states and symbols
-	flag.IntVar(&options.TileWidth, "tile-width", 32, "tile width in pixels")
-	flag.IntVar(&options.TileHeight, "tile-height", 24, "tile height in pixels")
-	flag.IntVar(&options.MaxDepth, "max-depth", 100, "maximum number of transitions")
-	flag.BoolVar(&options.IgnoreDepthFailure, "ignore-depth-failure", false, "proceed when MaxDepth is exceeded")
-	flag.StringVar(&options.FontPath, "font-path", "/usr/share/fonts/truetype/ttf-bitstream-vera/Vera.ttf", "path to a truetype font")
-	flag.Float64Var(&options.FontSize, "font-size", 12, "font size in points")
-	flag.IntVar(&options.Rotation, "rotation", 0, "rotation from 0-3")
-	flag.BoolVar(&options.FlipHorizontal, "flip-horizontal", false, "flip the output horizontally")
-	flag.BoolVar(&options.FlipVertical, "flip-vertical", false, "flip the output vertically")
-	flag.StringVar(&options.ColorTweak, "color-tweak", "", "string which consistently but unpredictably changes color selection")
-	flag.StringVar(&boundarySymbol, "boundary-symbol", "*", "boundary symbol")
-	flag.Parse()
-
-	if flag.NArg() < 2 {
-		log.Fatalf("usage: %s [options] <machine_spec> <input_string> [<input_string>] [...]", "tiler")
-	}
-
-	options.MachineFile = flag.Arg(0)
-	options.Inputs = flag.Args()[1:]
-	options.BoundarySymbol, _ = utf8.DecodeRune([]byte(boundarySymbol))
-
-	log.Printf("Processing %s, %v", options.MachineFile, options.Inputs)
-	tiler := options.NewTiler()
-	tiler.Assemble()
+	flags.IntVar(&options.TileWidth, "tile-width", 32, "tile width in pixels")
+	flags.IntVar(&options.TileHeight, "tile-height", 24, "tile height in pixels")
+	flags.StringVar(&options.FontPath, "font-path", "", "path to a truetype font (default the bundled Go font)")
+	flags.StringVar(&v.fallbackFonts, "fallback-fonts", "", "comma separated truetype fonts for characters the main font lacks")
+	flags.Float64Var(&options.FontSize, "font-size", 12, "font size in points")
+	flags.Float64Var(&options.MinFontSize, "min-font-size", 6, "smallest size labels shrink to when they don't fit")
+	flags.BoolVar(&options.AutoSize, "auto-size", false, "size tiles to fit the longest labels, overriding -tile-width and -tile-height")
+	flags.BoolVar(&options.ClassicLabels, "classic-labels", false, "place labels as assemble.pl did, at -font-size however long they are")
+	flags.IntVar(&options.Rotation, "rotation", 0, "rotation from 0-3")
+	flags.BoolVar(&options.FlipHorizontal, "flip-horizontal", false, "flip the output horizontally")
+	flags.BoolVar(&options.FlipVertical, "flip-vertical", false, "flip the output vertically")
+	flags.StringVar(&options.ColorTweak, "color-tweak", "", "string which consistently but unpredictably changes color selection")
+	flags.StringVar(&options.Palette, "palette", "hash", "bond colors: hash, or colorblind or grayscale, which pattern bonds once colors run out")
+	flags.StringVar(&options.Theme, "theme", "", "file of BACKGROUND, FINAL, LABEL and STATE colors overriding the palette")
+	flags.StringVar(&options.NameTemplate, "name-template", tiler.DefaultNameTemplate, "output file names, from {name}, {input}, {rotation} and {format}")
+	flags.StringVar(&options.Format, "format", "png", "image format: png, jpeg, gif, bmp or tiff")
+	flags.IntVar(&options.Quality, "quality", 90, "jpeg quality from 1-100")
+	return &v
+}
+
+func (v *drawingValues) apply(options *tiler.Options) {
+	options.FallbackFonts = splitList(v.fallbackFonts)
+}
+
+// the tileset command draws the whole tile pool in place of aux/rules-*.pl
+func tileset(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	var layout string
+	global := globalFlags(flags, &options)
+	drawing := drawingFlags(flags, &options)
+	flags.StringVar(&layout, "layout", "rows", "rows for a row per tileset, or square")
+	return func() {
+		if flags.NArg() < 1 {
+			log.Fatalf("usage: %s tileset [options] <machine_spec> [<machine_spec>] [...]", "tiler")
+		}
+		for _, file := range flags.Args() {
+			configure(flags, file)
+			global.apply(&options)
+			drawing.apply(&options)
+			options.MachineFile = file
+			options.NewTiler().WriteTileset(layout)
+		}
+	}
+}
+
+// the diff command compares two machines on the same input, or one machine
+// on two inputs, depending on whether the second argument is a file
+func diff(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	global := globalFlags(flags, &options)
+	drawing := drawingFlags(flags, &options)
+	flags.IntVar(&options.MaxDepth, "max-depth", 100, "maximum number of transitions")
+	return func() {
+		if flags.NArg() != 3 {
+			log.Fatalf("usage: %s diff [options] <machine_spec> <machine_spec> <input_string>\n"+
+				"   or: %s diff [options] <machine_spec> <input_string> <input_string>", "tiler", "tiler")
+		}
+		configure(flags, flags.Arg(0))
+		global.apply(&options)
+		drawing.apply(&options)
+		other := options
+		options.MachineFile = flags.Arg(0)
+		if info, err := os.Stat(flags.Arg(1)); err == nil && !info.IsDir() {
+			other.MachineFile = flags.Arg(1)
+			options.Inputs = []string{flags.Arg(2)}
+			other.Inputs = []string{flags.Arg(2)}
+		} else {
+			other.MachineFile = flags.Arg(0)
+			options.Inputs = []string{flags.Arg(1)}
+			other.Inputs = []string{flags.Arg(2)}
+		}
+		options.NewTiler().Diff(options.Inputs[0], other.NewTiler(), other.Inputs[0], os.Stdout)
+	}
+}
+
+// the run command assembles directories of examples in place of the run script,
+// exiting with status 1 if any input fails to halt
+func run(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	var jobs int
+	global := globalFlags(flags, &options)
+	drawing := drawingFlags(flags, &options)
+	flags.IntVar(&options.MaxDepth, "max-depth", 100, "maximum number of transitions")
+	flags.IntVar(&jobs, "jobs", runtime.NumCPU(), "inputs to assemble at once")
+	return func() {
+		dirs := flags.Args()
+		if len(dirs) == 0 {
+			dirs = []string{"."}
+		}
+		examples := tiler.FindExamples(dirs)
+		for i := range examples {
+			configure(flags, examples[i].MachineFile)
+			global.apply(&options)
+			drawing.apply(&options)
+			configured := options
+			examples[i].Options = &configured
+		}
+		if options.RunExamples(examples, jobs, os.Stdout) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// the test command simulates the cases of each machine, exiting with status 1
+// if any doesn't do what its inputs file expects
+func test(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	global := globalFlags(flags, &options)
+	flags.IntVar(&options.MaxDepth, "max-depth", 100000, "maximum number of transitions")
+	return func() {
+		paths := flags.Args()
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		examples := tiler.FindExamples(paths)
+		for i := range examples {
+			configure(flags, examples[i].MachineFile)
+			global.apply(&options)
+			configured := options
+			examples[i].Options = &configured
+		}
+		if options.TestExamples(examples, os.Stdout) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// the golden command redraws the example gallery and compares it with the
+// images checked in, exiting with status 1 if any has changed. the drawings are
+// pinned, so config files don't apply.
+func golden(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	var tolerance float64
+	var update bool
+	global := globalFlags(flags, &options)
+	flags.Float64Var(&tolerance, "tolerance", tiler.GoldenTolerance, "fraction of pixels which may differ visibly before an image fails")
+	flags.BoolVar(&update, "update", false, "replace the golden images which differ with the new drawings")
+	return func() {
+		paths := flags.Args()
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		configure(flags, "")
+		global.apply(&options)
+		if options.CheckGoldens(tiler.FindExamples(paths), tolerance, update, os.Stdout) > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// the serve command renders machines posted to it until it's killed
+func serve(flags *flag.FlagSet) func() {
+	var server tiler.Server
+	var listen string
+	global := globalFlags(flags, &server.Options)
+	drawing := drawingFlags(flags, &server.Options)
+	flags.StringVar(&listen, "listen", "localhost:8080", "address to listen on")
+	flags.IntVar(&server.MaxDepth, "max-depth", 10000, "most transitions a request may ask for, and what it gets by default")
+	flags.Int64Var(&server.MaxRequestBytes, "max-request-bytes", 64<<10, "largest request body accepted")
+	flags.DurationVar(&server.Timeout, "timeout", 30*time.Second, "longest a request may take")
+	flags.IntVar(&server.CacheSize, "cache-size", 64, "how many posted machines to keep the tiles of")
+	flags.IntVar(&server.MaxPixels, "max-pixels", 32<<20, "largest assembly or diagram image drawn, in pixels; 0 for no limit")
+	flags.IntVar(&server.MaxTraceCells, "max-trace-cells", 16<<20, "largest simulation trace returned, in tape cells over every step; 0 for no limit")
+	return func() {
+		if flags.NArg() > 0 {
+			log.Fatalf("usage: %s serve [options]", "tiler")
+		}
+		configure(flags, "")
+		global.apply(&server.Options)
+		drawing.apply(&server.Options)
+
+		log.Printf("Listening on %s...", listen)
+		log.Fatal(http.ListenAndServe(listen, &server))
+	}
+}
+
+// the diagram command draws state diagrams in place of machine2png.pl
+func diagram(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	var fallbackFonts, format string
+	var concise bool
+	global := globalFlags(flags, &options)
+	flags.StringVar(&format, "format", "png", "output format: dot, svg or png")
+	flags.BoolVar(&concise, "concise", true, "merge parallel transitions into one edge")
+	flags.StringVar(&options.FontPath, "font-path", "", "path to a truetype font for png output (default the bundled Go font)")
+	flags.StringVar(&fallbackFonts, "fallback-fonts", "", "comma separated truetype fonts for characters the main font lacks")
+	return func() {
+		if flags.NArg() < 1 {
+			log.Fatalf("usage: %s diagram [options] <machine_spec> [<machine_spec>] [...]", "tiler")
+		}
+		for _, file := range flags.Args() {
+			configure(flags, file)
+			global.apply(&options)
+			options.FallbackFonts = splitList(fallbackFonts)
+			log.Printf("Generating diagram for %s...", file)
+			options.MachineFile = file
+			options.WriteDiagram(format, concise)
+		}
+	}
+}
+
+// the simulate command runs a machine without tiles in place of simulate.pl
+func simulate(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	var delay time.Duration
+	var clear, all bool
+	global := globalFlags(flags, &options)
+	flags.IntVar(&options.MaxDepth, "max-depth", 100, "maximum number of transitions")
+	flags.DurationVar(&delay, "sleep", 0, "pause between steps, e.g. 200ms")
+	flags.BoolVar(&clear, "clear", false, "clear the screen before each step")
+	flags.BoolVar(&all, "all", true, "print every step on its own line, not only those which change the tape")
+	checkpointFlags(flags, &options)
+	return func() {
+		if flags.NArg() < 2 {
+			log.Fatalf("usage: %s simulate [options] <machine_spec> <input_string> [<input_string>] [...]", "tiler")
+		}
+		configure(flags, flags.Arg(0))
+		global.apply(&options)
+		options.MachineFile = flags.Arg(0)
+		t := options.NewMachineTiler()
+		for _, input := range flags.Args()[1:] {
+			log.Printf("Simulating for input %s...", input)
+			t.WriteSimulation(os.Stdout, input, delay, clear, all)
+		}
+	}
+}
+
+// the debug command steps through a run on the simulator, reading commands
+// from stdin
+func debug(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	var window int
+	global := globalFlags(flags, &options)
+	flags.IntVar(&options.MaxDepth, "max-depth", 100000, "most steps continue takes before stopping")
+	flags.IntVar(&window, "window", 30, "cells shown either side of the head")
+	return func() {
+		if flags.NArg() != 2 {
+			log.Fatalf("usage: %s debug [options] <machine_spec> <input_string>", "tiler")
+		}
+		configure(flags, flags.Arg(0))
+		global.apply(&options)
+		options.MachineFile = flags.Arg(0)
+		options.NewMachineTiler().Debug(flags.Arg(1), window, os.Stdin, os.Stdout)
+	}
+}
+
+// the lint command checks machines, exiting with status 1 if any has problems
+func lint(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	var complete bool
+	global := globalFlags(flags, &options)
+	flags.BoolVar(&complete, "complete", false, "also require a transition for every reachable state and symbol")
+	return func() {
+		if flags.NArg() < 1 {
+			log.Fatalf("usage: %s lint [options] <machine_spec> [<machine_spec>] [...]", "tiler")
+		}
+		problems := 0
+		for _, file := range flags.Args() {
+			configure(flags, file)
+			global.apply(&options)
+			options.MachineFile = file
+			problems += options.Lint(os.Stdout, complete)
+		}
+		if problems > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// the template command writes a skeleton machine in place of
+// generate_machine_template.pl, asking for anything not given by flags
+func template(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	var states int
+	var symbols string
+	global := globalFlags(flags, &options)
+	flags.IntVar(&states, "states", 0, "number of states")
+	flags.StringVar(&symbols, "symbols", "", "non-boundary symbols allowed on the tape, e.g. abc_")
+	return func() {
+		configure(flags, "")
+		global.apply(&options)
+
+		in := bufio.NewReader(os.Stdin)
+		ask := func(question string) string {
+			fmt.Fprint(os.Stderr, question+" ")
+			answer, _ := in.ReadString('\n')
+			return strings.TrimSpace(answer)
+		}
+		name := flags.Arg(0)
+		if name == "" {
+			name = ask("What is the name of this machine?")
+		}
+		for states < 1 {
+			n, err := strconv.Atoi(ask("How many states does this machine have?"))
+			if err != nil {
+				continue
+			}
+			states = n
+		}
+		if symbols == "" {
+			symbols = ask("What non-boundary symbols are allowed on the tape?")
+		}
+
+		var runes []rune
+		for _, r := range symbols {
+			if !unicode.IsSpace(r) {
+				runes = append(runes, r)
+			}
+		}
+		file := options.WriteTemplate(name, states, runes)
+		fmt.Fprintf(os.Stderr, "\n%s has been generated.\n", file)
+	}
+}
+
+// the seed command writes tape specifications in place of aux/make_tape_spec.pl
+func seed(flags *flag.FlagSet) func() {
+	var options tiler.Options
+	global := globalFlags(flags, &options)
+	flags.StringVar(&options.NameTemplate, "name-template", tiler.DefaultNameTemplate, "output file names, from {name}, {input}, {rotation} and {format}")
+	return func() {
+		if flags.NArg() < 2 {
+			log.Fatalf("usage: %s seed [options] <machine_spec> <input_string> [<input_string>] [...]", "tiler")
+		}
+		configure(flags, flags.Arg(0))
+		global.apply(&options)
+		options.MachineFile = flags.Arg(0)
+		t := options.NewMachineTiler()
+		for _, input := range flags.Args()[1:] {
+			t.WriteSeed(input)
+		}
+	}
+}
+
+// split a comma separated flag value, ignoring empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }